@@ -0,0 +1,95 @@
+// toml2php 命令行工具，将toml文件或标准输入的内容转换为PHP代码
+//
+// 用法：
+//
+//	toml2php [-mode auto|value|table] [-indent "    "] [-max-errors 1] [-partial] [-lenient] [-max-warnings 100] [-encoding GBK] [-detect-encoding] [-output-encoding GBK] [-replace-unencodable ?]
+//	         [-max-input-size 0] [-max-depth 1000] [-max-keys 0] [-max-array-length 0] [-max-string-length 0] [file]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/whencome/toml2php"
+)
+
+func main() {
+	mode := flag.String("mode", "auto", "parse mode: auto, value or table")
+	indent := flag.String("indent", toml2php.IndentString, "indent string of the generated code")
+	maxErrors := flag.Int("max-errors", 1, "maximum number of errors reported, 0 means no limit")
+	partial := flag.Bool("partial", false, "print the code generated from the valid statements when parsing fails")
+	encoding := flag.String("encoding", "UTF-8", "encoding of the input, such as GBK, GB18030, Big5")
+	detect := flag.Bool("detect-encoding", false, "parse valid UTF-8 input as UTF-8, and decode the others with -encoding")
+	outputEncoding := flag.String("output-encoding", "UTF-8", "encoding of the generated code, such as GBK, GB18030, Big5")
+	replace := flag.String("replace-unencodable", "", "replace the characters which cannot be represented in -output-encoding with this marker instead of failing")
+	maxInputSize := flag.Int("max-input-size", 0, "maximum size of the input in bytes, 0 means no limit")
+	maxDepth := flag.Int("max-depth", toml2php.MaxDepth, "maximum nesting depth of arrays and inline tables, and parts of a key, 0 means no limit")
+	maxKeys := flag.Int("max-keys", 0, "maximum number of key/value pairs and table headers, 0 means no limit")
+	maxArrayLength := flag.Int("max-array-length", 0, "maximum number of elements of an array, 0 means no limit")
+	maxStringLength := flag.Int("max-string-length", 0, "maximum length of a string or a key in bytes, 0 means no limit")
+	lenient := flag.Bool("lenient", false, "accept unquoted strings, trailing commas and duplicate keys, with warnings")
+	maxWarnings := flag.Int("max-warnings", toml2php.MaxWarnings, "maximum number of warnings reported in lenient mode, 0 means no limit")
+	flag.Parse()
+
+	var content []byte
+	var err error
+	if flag.NArg() > 0 {
+		content, err = ioutil.ReadFile(flag.Arg(0))
+	} else {
+		content, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := toml2php.SetInputEncoding(*encoding); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	toml2php.SetDetectInputEncoding(*detect)
+	if err := toml2php.SetOutputEncoding(*outputEncoding); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *replace != "" {
+		toml2php.SetUnencodableMode(toml2php.UnencodableReplace)
+		toml2php.SetUnencodableMarker(*replace)
+	}
+	toml2php.SetIndent(*indent)
+	toml2php.SetMaxErrors(*maxErrors)
+	toml2php.SetPartialResult(*partial)
+	toml2php.SetMaxInputSize(*maxInputSize)
+	toml2php.SetMaxDepth(*maxDepth)
+	toml2php.SetMaxKeys(*maxKeys)
+	toml2php.SetMaxArrayLength(*maxArrayLength)
+	toml2php.SetMaxStringLength(*maxStringLength)
+	if *lenient {
+		toml2php.SetParseMode(toml2php.ParseModeLenient)
+		toml2php.SetMaxWarnings(*maxWarnings)
+	}
+	var code string
+	var warnings toml2php.ParseErrors
+	switch *mode {
+	case "auto":
+		code, _, warnings, err = toml2php.ParseWithWarnings(string(content))
+	case "value":
+		code, warnings, err = toml2php.ParseSingleWithWarnings(string(content))
+	case "table":
+		code, warnings, err = toml2php.ParseTableWithWarnings(string(content))
+	default:
+		err = fmt.Errorf("unknown mode: %s", *mode)
+	}
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, "warning: "+warning.Error())
+	}
+	if code != "" {
+		fmt.Println(code)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
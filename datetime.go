@@ -0,0 +1,187 @@
+package toml2php
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// define the kinds of toml date-time values
+const (
+	DateTimeKindOffset = iota // 1979-05-27T07:32:00Z
+	DateTimeKindLocal         // 1979-05-27T07:32:00
+	DateKindLocal             // 1979-05-27
+	TimeKindLocal             // 07:32:00
+)
+
+// define how date-time values are written as php code
+const (
+	DateTimeAsString    = iota // quoted ISO-8601 string
+	DateTimeAsTimestamp        // integer unix timestamp
+	DateTimeAsObject           // new \DateTimeImmutable(...)
+)
+
+// DateTimeFormat the php output form of date-time values, default quoted ISO-8601 string
+var DateTimeFormat = DateTimeAsString
+
+// LocalTimeZone the time zone used for local date-times and local dates,
+// which have no offset information themselves
+var LocalTimeZone = time.UTC
+
+var (
+	dateTimeRegexp = regexp.MustCompile(`^(\d{4})-(\d{2})-(\d{2})(?:[Tt ](\d{2}):(\d{2})(?::(\d{2})(\.\d+)?)?(Z|z|[+-]\d{2}:\d{2})?)?$`)
+	timeRegexp     = regexp.MustCompile(`^(\d{2}):(\d{2})(?::(\d{2})(\.\d+)?)?$`)
+	dateRegexp     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	// a space followed by the start of a time, which may continue a date
+	spaceTimeRegexp = regexp.MustCompile(`^ \d{2}:`)
+)
+
+// PHPDateTime define a toml date-time value
+type PHPDateTime struct {
+	Time time.Time
+	Kind int
+}
+
+// isDateTime 判断给定的字符串是否是日期时间格式
+func isDateTime(str string) bool {
+	return dateTimeRegexp.MatchString(str) || timeRegexp.MatchString(str)
+}
+
+// isDateBeforeTime 判断date是否为日期，并且其后的rest以空格和时间开始，如1979-05-27 07:32:00，
+// toml允许用空格代替日期和时间之间的T
+func isDateBeforeTime(date, rest string) bool {
+	return dateRegexp.MatchString(date) && spaceTimeRegexp.MatchString(rest)
+}
+
+// parseDateTime parse offset date-times, local date-times, local dates and local times
+func parseDateTime(str string) (*PHPDateTime, error) {
+	if m := timeRegexp.FindStringSubmatch(str); m != nil {
+		if err := checkSeconds(str, m[3]); err != nil {
+			return nil, err
+		}
+		t, err := buildDateTime(str, []string{"0000", "01", "01", m[1], m[2], m[3], m[4]}, time.UTC)
+		if err != nil {
+			return nil, err
+		}
+		return &PHPDateTime{Time: t, Kind: TimeKindLocal}, nil
+	}
+	m := dateTimeRegexp.FindStringSubmatch(str)
+	if m == nil {
+		return nil, errors.New("Invalid date-time: " + str)
+	}
+	kind := DateTimeKindLocal
+	loc := LocalTimeZone
+	if m[4] == "" {
+		kind = DateKindLocal
+		m[4], m[5], m[6] = "00", "00", "00"
+	} else if err := checkSeconds(str, m[6]); err != nil {
+		return nil, err
+	} else if m[8] != "" {
+		kind = DateTimeKindOffset
+		var err error
+		loc, err = parseOffset(m[8])
+		if err != nil {
+			return nil, errors.New("Invalid date-time offset: " + str)
+		}
+	}
+	t, err := buildDateTime(str, m[1:8], loc)
+	if err != nil {
+		return nil, err
+	}
+	return &PHPDateTime{Time: t, Kind: kind}, nil
+}
+
+// checkSeconds 时间省略秒数需要toml 1.1
+func checkSeconds(str, seconds string) error {
+	if seconds == "" && SpecVersion < SpecVersion11 {
+		return errors.New("Invalid date-time: " + str + ", omitting seconds requires toml 1.1")
+	}
+	return nil
+}
+
+// buildDateTime build a time from the matched year, month, day, hour, minute, second and fraction,
+// out of range fields are reported as errors instead of being normalized
+func buildDateTime(str string, parts []string, loc *time.Location) (time.Time, error) {
+	n := make([]int, 6)
+	for i := 0; i < 6; i++ {
+		// omitted seconds are zero
+		if parts[i] == "" {
+			continue
+		}
+		n[i], _ = strconv.Atoi(parts[i])
+	}
+	nsec := 0
+	if parts[6] != "" {
+		frac := parts[6][1:]
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		frac += strings.Repeat("0", 9-len(frac))
+		nsec, _ = strconv.Atoi(frac)
+	}
+	if n[1] < 1 || n[1] > 12 || n[2] < 1 || n[3] > 23 || n[4] > 59 || n[5] > 59 {
+		return time.Time{}, errors.New("Invalid date-time: " + str)
+	}
+	t := time.Date(n[0], time.Month(n[1]), n[2], n[3], n[4], n[5], nsec, loc)
+	if t.Day() != n[2] {
+		return time.Time{}, errors.New("Invalid date-time: " + str)
+	}
+	return t, nil
+}
+
+// parseOffset parse the time zone offset, such as Z, +08:00, -07:00
+func parseOffset(offset string) (*time.Location, error) {
+	if offset == "Z" || offset == "z" {
+		return time.UTC, nil
+	}
+	hour, _ := strconv.Atoi(offset[1:3])
+	minute, _ := strconv.Atoi(offset[4:6])
+	if hour > 23 || minute > 59 {
+		return nil, errors.New("Invalid offset: " + offset)
+	}
+	seconds := hour*3600 + minute*60
+	if offset[0] == '-' {
+		seconds = -seconds
+	}
+	return time.FixedZone(offset, seconds), nil
+}
+
+// ISO8601 format the date-time as ISO-8601 string
+func (dt *PHPDateTime) ISO8601() string {
+	switch dt.Kind {
+	case DateTimeKindOffset:
+		return dt.Time.Format("2006-01-02T15:04:05.999999999Z07:00")
+	case DateTimeKindLocal:
+		return dt.Time.Format("2006-01-02T15:04:05.999999999")
+	case DateKindLocal:
+		return dt.Time.Format("2006-01-02")
+	}
+	return dt.Time.Format("15:04:05.999999999")
+}
+
+// String format the date-time as php code according to DateTimeFormat.
+// Local times have no date part, so they are always written as strings.
+func (dt *PHPDateTime) String() string {
+	if dt.Kind == TimeKindLocal {
+		return fmtPhpString(dt.ISO8601())
+	}
+	switch DateTimeFormat {
+	case DateTimeAsTimestamp:
+		return strconv.FormatInt(dt.Time.Unix(), 10)
+	case DateTimeAsObject:
+		// php keeps microseconds only
+		iso := dt.Time.Format("2006-01-02T15:04:05.999999")
+		zone := LocalTimeZone.String()
+		if dt.Kind == DateTimeKindOffset {
+			iso = dt.Time.Format("2006-01-02T15:04:05.999999Z07:00")
+			zone = dt.Time.Format("Z07:00")
+			if zone == "Z" {
+				zone = "UTC"
+			}
+		}
+		return "new \\DateTimeImmutable(" + fmtPhpString(iso) + ", new \\DateTimeZone(" + fmtPhpString(zone) + "))"
+	}
+	return fmtPhpString(dt.ISO8601())
+}
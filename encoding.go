@@ -0,0 +1,186 @@
+package toml2php
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/axgle/mahonia"
+)
+
+// InputEncoding the encoding of the toml input, such as GBK, GB18030, Big5, default UTF-8.
+// The input is decoded to UTF-8 before parsing, the input with a UTF-8 BOM is always parsed as UTF-8.
+var InputEncoding = "UTF-8"
+
+// DetectInputEncoding whether to parse the input which is valid UTF-8 as UTF-8, and decode the other input
+// with InputEncoding, or GB18030 if InputEncoding is UTF-8. This allows UTF-8 and legacy files to be mixed.
+var DetectInputEncoding = false
+
+// OutputEncoding the encoding of the generated php code, such as GBK, GB18030, Big5, default UTF-8.
+var OutputEncoding = "UTF-8"
+
+// define how to handle the characters which cannot be represented in OutputEncoding
+const (
+	UnencodableError   = iota // return an error, the default
+	UnencodableReplace        // replace the character with UnencodableMarker
+)
+
+// UnencodableMode how to handle the characters which cannot be represented in OutputEncoding
+var UnencodableMode = UnencodableError
+
+// UnencodableMarker the replacement of the characters which cannot be represented in OutputEncoding
+var UnencodableMarker = "?"
+
+// encodingNameReplacer removes the separators in encoding names
+var encodingNameReplacer = strings.NewReplacer("-", "", "_", "")
+
+// isUTF8Encoding 判断编码名称是否表示UTF-8
+func isUTF8Encoding(name string) bool {
+	name = strings.ToLower(encodingNameReplacer.Replace(name))
+	return name == "" || name == "utf8"
+}
+
+// checkEncoding 检查编码是否受支持
+func checkEncoding(name string) error {
+	if isUTF8Encoding(name) || mahonia.GetCharset(name) != nil {
+		return nil
+	}
+	return errors.New("Unsupported encoding: " + name)
+}
+
+// decodeInput 将输入转换为UTF-8编码。输入需要转换时，同时返回转换后的每个字节偏移对应的原始字节偏移，
+// 最后一个元素对应输入的末尾，用于在错误信息中报告原始输入中的位置；无需转换时返回nil。
+func decodeInput(input string) (string, []int, error) {
+	encoding := InputEncoding
+	if strings.HasPrefix(input, "\uFEFF") {
+		return input, nil, nil
+	}
+	if DetectInputEncoding {
+		if utf8.ValidString(input) {
+			return input, nil, nil
+		}
+		if isUTF8Encoding(encoding) {
+			encoding = "GB18030"
+		}
+	}
+	if isUTF8Encoding(encoding) {
+		return input, nil, nil
+	}
+	decoder := mahonia.NewDecoder(encoding)
+	if decoder == nil {
+		return "", nil, errors.New("Unsupported encoding: " + encoding)
+	}
+
+	data := []byte(input)
+	var buf strings.Builder
+	buf.Grow(len(input) * 3 / 2)
+	offsets := make([]int, 0, len(input)*3/2+1)
+	line, column, lineStart := 1, 1, 0
+	for pos := 0; pos < len(input); {
+		r, size, status := decoder(data[pos:])
+		if status == mahonia.STATE_ONLY && size > 0 {
+			pos += size
+			continue
+		}
+		if status != mahonia.SUCCESS {
+			source := buf.String()[lineStart:]
+			msg := fmt.Sprintf("Invalid %s sequence", encoding)
+			return "", nil, &ParseError{Kind: ErrorKindEncoding, Message: msg, Offset: pos, Line: line, Column: column, Source: source}
+		}
+		n, _ := buf.WriteRune(r)
+		for i := 0; i < n; i++ {
+			offsets = append(offsets, pos)
+		}
+		pos += size
+		if r == '\n' {
+			line++
+			column = 1
+			lineStart = buf.Len()
+		} else if r != '\r' {
+			column++
+		}
+	}
+	offsets = append(offsets, len(input))
+	return buf.String(), offsets, nil
+}
+
+// encodeRune 将字符转换为OutputEncoding编码，无法表示时返回false
+func encodeRune(encoder mahonia.Encoder, r rune) ([]byte, bool) {
+	if r < utf8.RuneSelf {
+		return []byte{byte(r)}, true
+	}
+	buf := make([]byte, 8)
+	size, status := encoder(buf, r)
+	return buf[:size], status == mahonia.SUCCESS
+}
+
+// unencodableError 返回字符无法用OutputEncoding表示的错误
+func unencodableError(r rune) error {
+	return fmt.Errorf("Character %q (U+%04X) cannot be represented in %s", r, r, OutputEncoding)
+}
+
+// fmtEncodedPhpString 格式化为OutputEncoding编码的代码中的PHP字符串，返回的字面量仍为UTF-8，由encodeOutput统一转换。
+// GBK、Big5等编码中部分字符的第二个字节为\，在PHP中会与其后的引号或反斜杠组成转义，
+// 包含这些字符的字符串使用双引号字符串，并将这些字符写为\xHH的形式
+func fmtEncodedPhpString(str string, encoder mahonia.Encoder) string {
+	hasBackslash := false
+	var replaced strings.Builder
+	for _, c := range str {
+		encoded, ok := encodeRune(encoder, c)
+		if !ok && UnencodableMode == UnencodableReplace {
+			replaced.WriteString(UnencodableMarker)
+			continue
+		}
+		if c >= utf8.RuneSelf && bytes.IndexByte(encoded, '\\') >= 0 {
+			hasBackslash = true
+		}
+		replaced.WriteRune(c)
+	}
+	str = replaced.String()
+	if !hasBackslash {
+		return quotePhpString(str)
+	}
+
+	var buf strings.Builder
+	buf.WriteRune('"')
+	for _, c := range str {
+		switch c {
+		case '\\', '"', '$':
+			buf.WriteRune('\\')
+		}
+		if encoded, ok := encodeRune(encoder, c); ok && c >= utf8.RuneSelf && bytes.IndexByte(encoded, '\\') >= 0 {
+			for _, b := range encoded {
+				buf.WriteString(fmt.Sprintf("\\x%02X", b))
+			}
+			continue
+		}
+		buf.WriteRune(c)
+	}
+	buf.WriteRune('"')
+	return buf.String()
+}
+
+// encodeOutput 将生成的PHP代码转换为OutputEncoding编码，无法表示的字符按照UnencodableMode处理
+func encodeOutput(code string) (string, error) {
+	if isUTF8Encoding(OutputEncoding) {
+		return code, nil
+	}
+	encoder := mahonia.NewEncoder(OutputEncoding)
+	if encoder == nil {
+		return "", errors.New("Unsupported encoding: " + OutputEncoding)
+	}
+	var buf strings.Builder
+	buf.Grow(len(code))
+	for _, c := range code {
+		// the characters in string literals are replaced by fmtPhpString in UnencodableReplace mode,
+		// so only an unencodable marker can fail here
+		encoded, ok := encodeRune(encoder, c)
+		if !ok {
+			return "", unencodableError(c)
+		}
+		buf.Write(encoded)
+	}
+	return buf.String(), nil
+}
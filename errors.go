@@ -0,0 +1,103 @@
+package toml2php
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// define the kinds of parse errors
+const (
+	ErrorKindSyntax   = iota // malformed toml, such as unterminated strings, missing brackets or misplaced tokens
+	ErrorKindValue           // values which cannot be converted, such as invalid numbers, date-times or escapes
+	ErrorKindConflict        // keys and tables defined more than once, or extended in a way toml forbids
+	ErrorKindEncoding        // invalid UTF-8 sequences and control characters
+	ErrorKindLimit           // input exceeding the resource limits, such as MaxInputSize and MaxDepth
+)
+
+// ParseError 解析错误，包含错误在输入中的位置以及出错的行，可以通过errors.As获取
+type ParseError struct {
+	Kind    int    // one of the ErrorKind constants
+	Message string // the description of the error without position
+	Offset  int    // byte offset in the input
+	Line    int    // line number, starting from 1
+	Column  int    // column number in characters, starting from 1
+	Source  string // the line of the input where the error occurs, without the line ending
+}
+
+// newParseError 创建解析错误，source为出错的行，不包含换行符
+func newParseError(kind int, source string, offset, line, column int, msg string) *ParseError {
+	return &ParseError{Kind: kind, Message: msg, Offset: offset, Line: line, Column: column, Source: source}
+}
+
+// Error 返回错误信息，并在出错的行下方用^标出出错的位置，如：
+//
+//	Key/value pairs have to be on a line by themselves, found "2" at line 1, column 7:
+//	a = 1 2
+//	      ^
+func (e *ParseError) Error() string {
+	var buf strings.Builder
+	buf.WriteString(e.Message)
+	buf.WriteString(" at line " + strconv.Itoa(e.Line) + ", column " + strconv.Itoa(e.Column))
+	if e.Source == "" {
+		return buf.String()
+	}
+	buf.WriteString(":\n")
+	buf.WriteString(e.Source)
+	buf.WriteString("\n")
+	// keep tabs and wide characters so that the caret lines up with the source
+	col := 1
+	for _, r := range e.Source {
+		if col >= e.Column {
+			break
+		}
+		switch {
+		case r == '\t':
+			buf.WriteRune('\t')
+		case isWideRune(r):
+			buf.WriteString("  ")
+		default:
+			buf.WriteRune(' ')
+		}
+		col++
+	}
+	buf.WriteRune('^')
+	return buf.String()
+}
+
+// isWideRune 判断字符在终端中是否占两个字符宽度，如中日韩文字以及全角符号
+func isWideRune(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hangul, unicode.Hiragana, unicode.Katakana) ||
+		(r >= 0x3000 && r <= 0x303f) || (r >= 0xff01 && r <= 0xff60) || (r >= 0xffe0 && r <= 0xffe6)
+}
+
+// ParseErrors 一次解析中收集到的多个解析错误，按在输入中出现的先后顺序排列
+type ParseErrors []*ParseError
+
+// Error 返回所有错误的信息，每个错误之间以空行分隔
+func (errs ParseErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n\n")
+}
+
+// As 将第一个错误赋值给*ParseError类型的target，使errors.As在Go 1.20之前的版本中也可以获取其中的*ParseError
+func (errs ParseErrors) As(target interface{}) bool {
+	parseErr, ok := target.(**ParseError)
+	if !ok || len(errs) == 0 {
+		return false
+	}
+	*parseErr = errs[0]
+	return true
+}
+
+// Unwrap 返回所有错误，Go 1.20及以上版本的errors.Is和errors.As可以检查其中的每个错误
+func (errs ParseErrors) Unwrap() []error {
+	list := make([]error, 0, len(errs))
+	for _, err := range errs {
+		list = append(list, err)
+	}
+	return list
+}
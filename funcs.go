@@ -3,8 +3,14 @@ package toml2php
 import (
 	"bytes"
 	"errors"
+	"math"
+	"math/big"
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/axgle/mahonia"
 )
 
 // runeInArray 判断给定的rune是否在数组中
@@ -21,256 +27,293 @@ func runeInArray(r rune, arr []rune) bool {
 	return false
 }
 
-// runesContains 判断rune列表中是否包含某个值
-func runesContains(arr []rune, r rune) bool {
-	for _, v := range arr {
+// runesIndex 返回rune在列表中第一次出现的位置，不存在时返回-1
+func runesIndex(arr []rune, r rune) int {
+	for i, v := range arr {
 		if v == r {
-			return true
+			return i
 		}
 	}
-	return false
+	return -1
+}
+
+// truncateString 将字符串截断为不超过size字节，不会截断UTF-8字符
+func truncateString(str string, size int) string {
+	if len(str) <= size {
+		return str
+	}
+	for size > 0 && !utf8.RuneStart(str[size]) {
+		size--
+	}
+	return str[:size]
 }
 
+// define toml number patterns, underscores must be surrounded by digits
+var (
+	decIntRegexp       = regexp.MustCompile(`^[+-]?(0|[1-9](_?\d)*)$`)
+	hexIntRegexp       = regexp.MustCompile(`^0x[0-9A-Fa-f](_?[0-9A-Fa-f])*$`)
+	octIntRegexp       = regexp.MustCompile(`^0o[0-7](_?[0-7])*$`)
+	binIntRegexp       = regexp.MustCompile(`^0b[01](_?[01])*$`)
+	floatRegexp        = regexp.MustCompile(`^[+-]?(0|[1-9](_?\d)*)(\.\d(_?\d)*)?([eE][+-]?\d(_?\d)*)?$`)
+	specialFloatRegexp = regexp.MustCompile(`^[+-]?(inf|nan)$`)
+	// anything starting like a number, used to report invalid numbers
+	numberLikeRegexp  = regexp.MustCompile(`^[+-]?(\d|\.\d)[\w.+-]*$`)
+	leadingZeroRegexp = regexp.MustCompile(`^[+-]?0\d`)
+)
+
 // isNumeric 判断给定的字符串是否是数字
 func isNumeric(str string) bool {
-	matched, err := regexp.MatchString(`^(\+|\-)?(0|[1-9]\d*)((\.\d+)?((e|E)(\+|\-)?[1-9]\d*)?)?$`, str)
+	return decIntRegexp.MatchString(str) ||
+		hexIntRegexp.MatchString(str) ||
+		octIntRegexp.MatchString(str) ||
+		binIntRegexp.MatchString(str) ||
+		floatRegexp.MatchString(str) ||
+		specialFloatRegexp.MatchString(str)
+}
+
+// isInteger 判断给定的字符串是否是整数（包括十六进制、八进制、二进制）
+func isInteger(str string) bool {
+	return decIntRegexp.MatchString(str) ||
+		hexIntRegexp.MatchString(str) ||
+		octIntRegexp.MatchString(str) ||
+		binIntRegexp.MatchString(str)
+}
+
+// parsePHPNumber 解析数字，整数必须在64位有符号整数范围内，并根据PHPIntSize检查目标平台的整数范围；
+// 浮点数必须可以用64位浮点数表示，输出时保留原始写法，以保证精度
+func parsePHPNumber(str string) (*PHPValue, error) {
+	if specialFloatRegexp.MatchString(str) {
+		return NewPHPNumberValue(str), nil
+	}
+	if !isInteger(str) {
+		if _, err := strconv.ParseFloat(strings.ReplaceAll(str, "_", ""), 64); err != nil {
+			return nil, errors.New("Float out of range: " + str)
+		}
+		return NewPHPNumberValue(str), nil
+	}
+	// base 0 handles the 0x, 0o, 0b prefixes and the underscores
+	n, err := strconv.ParseInt(str, 0, 64)
 	if err != nil {
-		return false
+		return nil, errors.New("Integer out of 64-bit range: " + str)
 	}
-	return matched
+	if PHPIntSize == 32 && (n > math.MaxInt32 || n < math.MinInt32) {
+		if IntOverflowMode == IntOverflowString {
+			return NewPHPStringValue(strconv.FormatInt(n, 10)), nil
+		}
+		return nil, errors.New("Integer out of PHP_INT_MAX range on 32-bit php: " + str)
+	}
+	return NewPHPNumberValue(str), nil
 }
 
-func isPositiveIntNumeric(str string) bool {
-	matched, err := regexp.MatchString(`^(0|[1-9]\d*)$`, str)
-	if err != nil {
-		return false
+// checkNumberLike 检查形似数字但不符合toml规范的值，如前导零
+func checkNumberLike(str string) error {
+	if !numberLikeRegexp.MatchString(str) {
+		return nil
+	}
+	if leadingZeroRegexp.MatchString(str) {
+		return errors.New("Leading zeros are not allowed in number: " + str)
 	}
-	return matched
+	return errors.New("Invalid number: " + str)
 }
 
-// fmtPhpString 格式化为PHP字符串形式
+// fmtPhpNumber 格式化为PHP数字形式，根据TargetPHPVersion决定是否保留进制前缀和下划线
+func fmtPhpNumber(str string) string {
+	// special float values
+	switch strings.TrimPrefix(str, "+") {
+	case "inf":
+		return "INF"
+	case "-inf":
+		return "-INF"
+	case "nan", "-nan":
+		return "NAN"
+	}
+	if expr, ok := phpMinIntExpr(strings.ReplaceAll(str, "_", "")); ok {
+		return expr
+	}
+	base := 10
+	if len(str) > 2 && str[0] == '0' {
+		switch str[1] {
+		case 'x':
+			base = 16
+		case 'o':
+			base = 8
+		case 'b':
+			base = 2
+		}
+	}
+	// keep the literal as it is when the target php version supports it
+	if TargetPHPVersion >= phpVersionNumericSeparator || !strings.Contains(str, "_") {
+		switch {
+		case base == 10 || base == 16:
+			return str
+		case base == 8 && TargetPHPVersion >= phpVersionExplicitOctal:
+			return str
+		case base == 8:
+			return "0" + str[2:]
+		case base == 2 && TargetPHPVersion >= phpVersionBinaryLiteral:
+			return str
+		}
+	}
+	digits := strings.ReplaceAll(str, "_", "")
+	if base == 10 {
+		return digits
+	}
+	n, ok := new(big.Int).SetString(digits[2:], base)
+	if !ok {
+		return digits
+	}
+	return n.String()
+}
+
+// phpMinIntExpr 返回目标平台最小整数的PHP表达式。PHP将-9223372036854775808解析为对溢出的正数取负，
+// 结果为浮点数，因此最小整数需要写成表达式
+func phpMinIntExpr(digits string) (string, bool) {
+	switch {
+	case PHPIntSize == 32 && digits == "-2147483648":
+		return "(-2147483647 - 1)", true
+	case PHPIntSize != 32 && digits == "-9223372036854775808":
+		return "(-9223372036854775807 - 1)", true
+	}
+	return "", false
+}
+
+// positiveIntRegexp the keys which are written as integer keys of php arrays
+var positiveIntRegexp = regexp.MustCompile(`^(0|[1-9]\d*)$`)
+
+func isPositiveIntNumeric(str string) bool {
+	return positiveIntRegexp.MatchString(str)
+}
+
+// fmtPhpString 格式化为PHP单引号字符串，生成的字面量与原字符串的值完全一致；
+// 设置了OutputEncoding时，按照目标编码处理无法表示的字符以及第二个字节为\的字符
 func fmtPhpString(str string) string {
-	str = strings.TrimSpace(str)
-	if str == "" {
-		return "''"
+	if !isUTF8Encoding(OutputEncoding) {
+		if encoder := mahonia.NewEncoder(OutputEncoding); encoder != nil {
+			return fmtEncodedPhpString(str, encoder)
+		}
 	}
-	chars := []rune(str)
-	charsSize := len(chars)
+	return quotePhpString(str)
+}
+
+// quotePhpString 格式化为PHP单引号字符串
+func quotePhpString(str string) string {
 	buffer := bytes.Buffer{}
 	buffer.WriteRune('\'')
-	for i := 0; i < charsSize; i++ {
-		if chars[i] == '\'' && ((i ==0) || (i > 0 && chars[i-1] != '\\')) {
+	for _, c := range str {
+		// 单引号字符串中只有\\和\'需要转义
+		if c == '\'' || c == '\\' {
 			buffer.WriteRune('\\')
-			buffer.WriteRune('\'')
-			continue
 		}
-		// 去除转义
-		if chars[i] == '\\' {
-			if i+1 < charsSize {
-				// 引号
-				if chars[i+1] == '"' {
-					buffer.WriteRune('"')
-					i += 1
-					continue
-				}
-				// 换行符
-				if chars[i+1] == 'n' {
-					buffer.WriteRune('\n')
-					i += 1
-					continue
-				}
-				// 转义符
-				if chars[i+1] == '\\' {
-					buffer.WriteRune('\\')
-					i += 1
-					continue
-				}
-				// 其他情形直接输出转义的字符
-				buffer.WriteRune(chars[i+1])
-				i += 1
-				continue
-				// // 制表符
-				// if chars[i+1] == 't' {
-				// 	buffer.WriteRune('\t')
-				// 	i += 1
-				// 	continue
-				// }
-				// if chars[i+1] == 'v' {
-				// 	buffer.WriteRune('\v')
-				// 	i += 1
-				// 	continue
-				// }
-			}
-		}
-		if chars[i] == '\n' {
-			//	buffer.WriteRune('\\')
-			//	buffer.WriteRune('n')
-			buffer.WriteRune('\n')
-			continue
-		}
-		if (i == 0 && chars[i] == '\\' && (i+1 == charsSize || chars[i+1] == '\n')) ||
-			(i > 0 && chars[i] == '\\' && chars[i-1] != '\\' && (i+1 == charsSize || chars[i+1] == '\n')) {
-			continue
-		}
-		buffer.WriteRune(chars[i])
+		buffer.WriteRune(c)
 	}
 	buffer.WriteRune('\'')
 	return buffer.String()
 }
 
-// normalize 对输入的配置进行标准化处理，以便于后续解析
-func normalize(snippet string) (string, error) {
-	// 处理换行符
-	snippet = strings.ReplaceAll(snippet, "\r\n", "\n")
-	snippet = strings.ReplaceAll(snippet, "\n\r", "\n")
-	// 处理制表符（\t）
-	snippet = strings.ReplaceAll(snippet, "\t", "")
+// escapeError 转义字符错误，offset为出错的转义字符在字符串中的字节偏移
+type escapeError struct {
+	msg    string
+	offset int
+}
+
+func (e *escapeError) Error() string {
+	return e.msg
+}
 
-	// Run, char by char.
-	normalized := ""
-	openString := false
-	openLString := false
-	openMString := false
-	openMLString := false
-	openBrackets := 0
-	openKeygroup := false
-	lineBuffer := ""
+// newEscapeError 创建转义字符错误，pos为反斜杠在chars中的位置
+func newEscapeError(chars []rune, pos int, msg string) error {
+	return &escapeError{msg: msg, offset: len(string(chars[:pos]))}
+}
 
-	chars := []rune(snippet)
+// unescapeBasicString 解码基本字符串中的转义字符，multiline表示是否为多行基本字符串，多行字符串中的CRLF换行符将被转换为LF
+func unescapeBasicString(str string, multiline bool) (string, error) {
+	chars := []rune(str)
 	charsSize := len(chars)
+	buffer := bytes.Buffer{}
 	for i := 0; i < charsSize; i++ {
-		keep := true
-		if chars[i] == '[' && !openString && !openLString && !openMString && !openMLString {
-			openBrackets++
-			if openBrackets == 1 && strings.TrimSpace(lineBuffer) == "" {
-				openKeygroup = true
+		if multiline && chars[i] == '\r' && i+1 < charsSize && chars[i+1] == '\n' {
+			continue
+		}
+		if chars[i] != '\\' {
+			buffer.WriteRune(chars[i])
+			continue
+		}
+		start := i
+		if i+1 >= charsSize {
+			return "", newEscapeError(chars, start, "Unterminated escape sequence in string")
+		}
+		i++
+		switch chars[i] {
+		case 'b':
+			buffer.WriteRune('\b')
+		case 't':
+			buffer.WriteRune('\t')
+		case 'n':
+			buffer.WriteRune('\n')
+		case 'f':
+			buffer.WriteRune('\f')
+		case 'r':
+			buffer.WriteRune('\r')
+		case '"':
+			buffer.WriteRune('"')
+		case '\\':
+			buffer.WriteRune('\\')
+		case 'e':
+			// toml 1.1: escape character
+			if SpecVersion < SpecVersion11 {
+				return "", newEscapeError(chars, start, "Invalid escape sequence in string: \\e, which requires toml 1.1")
 			}
-		} else if chars[i] == ']' && !openString && !openLString && !openMString && !openMLString {
-			if openBrackets > 0 {
-				openBrackets--
-				if openKeygroup {
-					openKeygroup = false
-				}
-			} else {
-				return "", errors.New("Unexpected ']' on : " + lineBuffer)
+			buffer.WriteRune('\x1b')
+		case 'x':
+			// toml 1.1: \xHH, the code point U+00HH
+			if SpecVersion < SpecVersion11 {
+				return "", newEscapeError(chars, start, "Invalid escape sequence in string: \\x, which requires toml 1.1")
 			}
-		} else if openBrackets > 0 && chars[i] == '\n' {
-			if openKeygroup {
-				return "", errors.New("Multi-line keygroup definition is not allowed on: " + lineBuffer)
+			if i+2 >= charsSize {
+				return "", newEscapeError(chars, start, "Invalid hex escape sequence in string")
 			}
-			keep = false
-		} else if (openString || openLString) && chars[i] == '\n' {
-			return "", errors.New("Multi-line string not allowed on: " + lineBuffer)
-		} else if ((i > 0 && chars[i] == '"' && chars[i-1] != '\\') || (i == 0 && chars[i] == '"')) && !openLString && !openMLString {
-			if charsSize >= i+3 && string(chars[i:i+3]) == `"""` {
-				i += 2
-				normalized += `"""`
-				lineBuffer += `"""`
-				keep = false
-				openMString = !openMString
-			} else if !openMString {
-				openString = !openString
+			hex := string(chars[i+1 : i+3])
+			code, err := strconv.ParseUint(hex, 16, 8)
+			if err != nil {
+				return "", newEscapeError(chars, start, "Invalid hex escape sequence: \\x"+hex)
 			}
-		} else if chars[i] == '\'' && !openString && !openMString {
-			if charsSize >= i+3 && string(chars[i:i+3]) == "'''" {
-				i += 2
-				normalized += "'''"
-				lineBuffer += "'''"
-				keep = false
-				openMLString = !openMLString
-			} else if !openMLString {
-				openLString = !openLString
+			buffer.WriteRune(rune(code))
+			i += 2
+		case 'u', 'U':
+			size := 4
+			if chars[i] == 'U' {
+				size = 8
 			}
-		} else if chars[i] == '\\' && chars[i-1] != '\\' && !runeInArray(chars[i+1], []rune{'b', 't', 'n', 'f', 'r', 'u', 'U', '"', '\\', ' '}) {
-			if openString {
-				return "", errors.New("Reserved special characters inside strings are not allowed: " + string(chars[i]) + string(chars[i+1]))
+			if i+size >= charsSize {
+				return "", newEscapeError(chars, start, "Invalid unicode escape sequence in string")
 			}
-			if openMString {
-				for {
-					if chars[i] == '\n' || chars[i+1] == ' ' {
-						i++
-						keep = false
-					} else {
-						break
-					}
-				}
+			hex := string(chars[i+1 : i+1+size])
+			code, err := strconv.ParseUint(hex, 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return "", newEscapeError(chars, start, "Invalid unicode code point: \\"+string(chars[i])+hex)
 			}
-		} else if chars[i] == '#' && !openString && !openKeygroup {
-			for {
-				if i >= charsSize || chars[i] == '\n' {
-					break
+			buffer.WriteRune(rune(code))
+			i += size
+		default:
+			// line ending backslash, trim all whitespace and newlines up to the next non-whitespace character
+			if multiline {
+				j := i
+				for j < charsSize && (chars[j] == ' ' || chars[j] == '\t') {
+					j++
+				}
+				if j+1 < charsSize && chars[j] == '\r' && chars[j+1] == '\n' {
+					j++
+				}
+				if j < charsSize && chars[j] == '\n' {
+					for j < charsSize && runeInArray(chars[j], []rune{' ', '\t', '\r', '\n'}) {
+						j++
+					}
+					i = j - 1
+					continue
 				}
-				i++
-			}
-			keep = openBrackets == 0
-		}
-
-		// raw lines
-		if i < charsSize {
-			lineBuffer += string(chars[i])
-			if chars[i] == '\n' {
-				lineBuffer = ""
-			}
-			if keep {
-				normalized += string(chars[i])
-			}
-		}
-	}
-
-	// Something went wrong.
-	if openBrackets > 0 {
-		return "", errors.New("Syntax error found on TOML document. Missing closing bracket.")
-	}
-	if openString {
-		return "", errors.New("Syntax error found on TOML document. Missing closing string delimiter.")
-	}
-	if openMString {
-		return "", errors.New("Syntax error found on TOML document. Missing closing multi-line string delimiter.")
-	}
-	if openLString {
-		return "", errors.New("Syntax error found on TOML document. Missing closing literal string delimiter.")
-	}
-	if openMLString {
-		return "", errors.New("Syntax error found on TOML document. Missing closing multi-line literal string delimiter.")
-	}
-	if openKeygroup {
-		return "", errors.New("Syntax error found on TOML document. Missing closing key group delimiter.")
-	}
-
-	return normalized, nil
-}
-
-// parseTableName Parses TOML table names and returns the hierarchy array of table names.
-func parseTableName(chars []rune) []string {
-	buffer := bytes.Buffer{}
-	strOpen := false
-	var strOpenChar rune
-	names := make([]string, 0)
-	charsSize := len(chars)
-	for i := 0; i < charsSize; i++ {
-		if chars[i] == '"' {
-			if !strOpen || (strOpen && i > 0 &&  chars[i-1] != '\\' && strOpenChar == chars[i]) {
-				strOpen = !strOpen
-			}
-			if strOpen {
-				strOpenChar = chars[i]
-			}
-		} else if chars[i] == '\'' {
-			if !strOpen || (strOpen && i > 0 && chars[i-1] != '\\' && strOpenChar == chars[i]) {
-				strOpen = !strOpen
-			}
-			if strOpen {
-				strOpenChar = chars[i]
 			}
-		} else if chars[i] == '.' && !strOpen {
-			names = append(names, buffer.String())
-			buffer.Reset()
-			continue
+			return "", newEscapeError(chars, start, "Invalid escape sequence in string: \\"+string(chars[i]))
 		}
-		buffer.WriteRune(chars[i])
-	}
-	if buffer.Len() > 0 {
-		names = append(names, buffer.String())
 	}
-	return names
+	return buffer.String(), nil
 }
@@ -0,0 +1,361 @@
+package toml2php
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// tokenType 词法单元的类型
+type tokenType int
+
+// define token types
+const (
+	tokenEOF                    tokenType = iota
+	tokenNewline                          // \n or \r\n
+	tokenBareKey                          // A-Za-z0-9_-, only in key mode
+	tokenScalar                           // booleans, numbers, date-times, only in value mode
+	tokenString                           // "basic string"
+	tokenLiteralString                    // 'literal string'
+	tokenMultilineString                  // """multi-line basic string"""
+	tokenMultilineLiteralString           // '''multi-line literal string'''
+	tokenEquals                           // =
+	tokenDot                              // .
+	tokenComma                            // ,
+	tokenLeftBracket                      // [
+	tokenRightBracket                     // ]
+	tokenDoubleLeftBracket                // [[, only in key mode
+	tokenDoubleRightBracket               // ]], only in key mode
+	tokenLeftBrace                        // {
+	tokenRightBrace                       // }
+)
+
+// define lexer modes, toml is context sensitive: 1.5 is a float as a value but two keys as a key
+const (
+	lexModeKey   = iota // keys, table headers and punctuations
+	lexModeValue        // values
+)
+
+// token 词法单元，Offset为在输入中的字节偏移，Line和Column从1开始，Column按字符计算，
+// LineStart为所在行开始的字节偏移
+type token struct {
+	typ       tokenType
+	text      string
+	offset    int
+	line      int
+	column    int
+	lineStart int
+}
+
+// with 返回从该位置开始的指定类型的词法单元
+func (t token) with(typ tokenType, text string) token {
+	t.typ, t.text = typ, text
+	return t
+}
+
+// end 返回词法单元结束的字节偏移
+func (t token) end() int {
+	return t.offset + len(t.text)
+}
+
+// lexer 词法分析器，按需逐个产生词法单元，空格、制表符以及注释会被跳过
+type lexer struct {
+	input  string
+	pos    int
+	line   int
+	column int
+	// the offset where the current line starts
+	lineStart int
+	// the last line returned by sourceLine and the offset where it starts, -1 if there is none
+	srcLine      string
+	srcLineStart int
+	// the end offset of the last token returned by next
+	prevEnd int
+	// the offsets in the original input of each byte, nil if the input is not decoded
+	offsets []int
+	// the first invalid character passed over since the current scan started
+	badChar *ParseError
+}
+
+// newLexer 创建词法分析器，非UTF-8编码的输入将按照InputEncoding转换为UTF-8，开头的UTF-8 BOM会被跳过，
+// 无法转换的输入以及超过MaxInputSize的输入将返回错误。无效的UTF-8编码和不允许的控制字符在扫描时报告。
+func newLexer(input string) (*lexer, error) {
+	if exceedsLimit(len(input), MaxInputSize) {
+		return nil, inputSizeError(input)
+	}
+	input, offsets, err := decodeInput(input)
+	if err != nil {
+		return nil, err
+	}
+	lx := &lexer{input: input, line: 1, column: 1, offsets: offsets, srcLineStart: -1}
+	if strings.HasPrefix(input, "\uFEFF") {
+		lx.pos = len("\uFEFF")
+		lx.prevEnd = lx.pos
+		lx.lineStart = lx.pos
+	}
+	return lx, nil
+}
+
+// errorf 生成pos位置的解析错误，错误中的偏移为在原始输入中的偏移
+func (lx *lexer) errorf(kind int, pos token, format string, args ...interface{}) *ParseError {
+	source := lx.sourceLine(pos.lineStart)
+	return newParseError(kind, source, lx.originalOffset(pos.offset), pos.line, pos.column, fmt.Sprintf(format, args...))
+}
+
+// sourceLine 返回从lineStart开始的一行，不包含换行符。上一次返回的行会被缓存，
+// 使同一行中的多个错误和警告不必重复查找行尾
+func (lx *lexer) sourceLine(lineStart int) string {
+	if lineStart != lx.srcLineStart {
+		end := strings.IndexByte(lx.input[lineStart:], '\n')
+		if end < 0 {
+			end = len(lx.input)
+		} else {
+			end += lineStart
+		}
+		lx.srcLine = strings.TrimSuffix(lx.input[lineStart:end], "\r")
+		lx.srcLineStart = lineStart
+	}
+	return lx.srcLine
+}
+
+// originalOffset 返回转换为UTF-8之前的原始输入中的偏移
+func (lx *lexer) originalOffset(offset int) int {
+	if lx.offsets == nil || offset < 0 || offset >= len(lx.offsets) {
+		return offset
+	}
+	return lx.offsets[offset]
+}
+
+// invalidChar 检查当前位置的字符是否为无效的UTF-8编码或不允许的控制字符，返回错误信息。
+// 除制表符和换行符（LF、CRLF）以外的控制字符只能通过转义的方式出现在字符串中。
+func (lx *lexer) invalidChar() string {
+	r, size := utf8.DecodeRuneInString(lx.input[lx.pos:])
+	if r == utf8.RuneError && size <= 1 {
+		return "Invalid UTF-8 sequence"
+	}
+	if (r < 0x20 && r != '\t' && r != '\n' && !(r == '\r' && lx.hasPrefix("\r\n"))) || r == 0x7f {
+		return fmt.Sprintf("Control character U+%04X is not allowed", r)
+	}
+	return ""
+}
+
+// advance 前进一个字符，并更新行号和列号，经过的第一个无效字符记录在badChar中
+func (lx *lexer) advance() {
+	if lx.badChar == nil {
+		if msg := lx.invalidChar(); msg != "" {
+			lx.badChar = lx.errorf(ErrorKindEncoding, lx.mark(), "%s", msg)
+		}
+	}
+	r, size := utf8.DecodeRuneInString(lx.input[lx.pos:])
+	lx.pos += size
+	if r == '\n' {
+		lx.line++
+		lx.column = 1
+		lx.lineStart = lx.pos
+	} else if r != '\r' {
+		lx.column++
+	}
+}
+
+// hasPrefix 判断当前位置是否以prefix开始
+func (lx *lexer) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(lx.input[lx.pos:], prefix)
+}
+
+// skipWhitespace 跳过空格、制表符以及注释，注释之后的换行符不会被跳过
+func (lx *lexer) skipWhitespace() {
+	for lx.pos < len(lx.input) {
+		switch lx.input[lx.pos] {
+		case ' ', '\t':
+			lx.advance()
+		case '#':
+			for lx.pos < len(lx.input) && lx.input[lx.pos] != '\n' && !lx.hasPrefix("\r\n") {
+				lx.advance()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// mark 返回当前的位置，可以通过reset回到该位置
+func (lx *lexer) mark() token {
+	return token{offset: lx.pos, line: lx.line, column: lx.column, lineStart: lx.lineStart}
+}
+
+// reset 回到mark返回的位置
+func (lx *lexer) reset(pos token) {
+	lx.pos, lx.line, lx.column, lx.lineStart = pos.offset, pos.line, pos.column, pos.lineStart
+}
+
+// peek 返回下一个词法单元，但不前进
+func (lx *lexer) peek(mode int) (token, error) {
+	pos := lx.mark()
+	tok, err := lx.scan(mode)
+	lx.reset(pos)
+	return tok, err
+}
+
+// skipLine 跳过当前行剩余的内容以及换行符，用于从词法错误中恢复
+func (lx *lexer) skipLine() {
+	for lx.pos < len(lx.input) && lx.input[lx.pos] != '\n' {
+		lx.advance()
+	}
+	if lx.pos < len(lx.input) {
+		lx.advance()
+	}
+}
+
+// next 返回下一个词法单元，并前进到该词法单元之后。词法单元中含有无效字符时，
+// 同时返回完整的词法单元和错误，以便从错误中恢复时可以跳过该词法单元
+func (lx *lexer) next(mode int) (token, error) {
+	tok, err := lx.scan(mode)
+	if err == nil && tok.typ != tokenEOF {
+		lx.prevEnd = tok.end()
+	}
+	return tok, err
+}
+
+// scan 扫描下一个词法单元，并检查扫描过的注释和词法单元中的字符
+func (lx *lexer) scan(mode int) (token, error) {
+	lx.badChar = nil
+	tok, err := lx.scanToken(mode)
+	if lx.badChar != nil {
+		return tok, lx.badChar
+	}
+	return tok, err
+}
+
+// scanToken 扫描下一个词法单元
+func (lx *lexer) scanToken(mode int) (token, error) {
+	lx.skipWhitespace()
+	start := lx.mark()
+	emit := func(typ tokenType) (token, error) {
+		return start.with(typ, lx.input[start.offset:lx.pos]), nil
+	}
+	if lx.pos >= len(lx.input) {
+		return emit(tokenEOF)
+	}
+	if msg := lx.invalidChar(); msg != "" {
+		return token{}, lx.errorf(ErrorKindEncoding, start, "%s", msg)
+	}
+
+	c := lx.input[lx.pos]
+	switch {
+	case c == '\n':
+		lx.advance()
+		return emit(tokenNewline)
+	case lx.hasPrefix("\r\n"):
+		lx.advance()
+		lx.advance()
+		return emit(tokenNewline)
+	case c == '"' || c == '\'':
+		return lx.scanString(start)
+	case c == '=':
+		lx.advance()
+		return emit(tokenEquals)
+	case c == ',':
+		lx.advance()
+		return emit(tokenComma)
+	case c == '{':
+		lx.advance()
+		return emit(tokenLeftBrace)
+	case c == '}':
+		lx.advance()
+		return emit(tokenRightBrace)
+	case mode == lexModeKey && lx.hasPrefix("[["):
+		lx.pos += 2
+		lx.column += 2
+		return emit(tokenDoubleLeftBracket)
+	case mode == lexModeKey && lx.hasPrefix("]]"):
+		lx.pos += 2
+		lx.column += 2
+		return emit(tokenDoubleRightBracket)
+	case c == '[':
+		lx.advance()
+		return emit(tokenLeftBracket)
+	case c == ']':
+		lx.advance()
+		return emit(tokenRightBracket)
+	case mode == lexModeKey && c == '.':
+		lx.advance()
+		return emit(tokenDot)
+	case mode == lexModeKey && isBareKeyChar(rune(c)):
+		for lx.pos < len(lx.input) && isBareKeyChar(rune(lx.input[lx.pos])) {
+			lx.advance()
+		}
+		return emit(tokenBareKey)
+	case mode == lexModeKey:
+		r, _ := utf8.DecodeRuneInString(lx.input[lx.pos:])
+		return token{}, lx.errorf(ErrorKindSyntax, start, "Invalid character %q in key", r)
+	}
+
+	// unquoted values run until whitespace or a separator, so that a missing comma or newline is reported
+	// at the next value, a date and a time separated by a space like 1979-05-27 07:32:00 are kept as one token
+	for {
+		for lx.pos < len(lx.input) && !strings.ContainsRune(" \t=,]}#\r\n", rune(lx.input[lx.pos])) {
+			lx.advance()
+		}
+		if !isDateBeforeTime(lx.input[start.offset:lx.pos], lx.input[lx.pos:]) {
+			return emit(tokenScalar)
+		}
+		lx.advance()
+	}
+}
+
+// scanString 扫描各种形式的字符串，字符串的内容在解析时解码，start为字符串开始的位置
+func (lx *lexer) scanString(start token) (token, error) {
+	quote := lx.input[lx.pos]
+	typ := tokenString
+	if quote == '\'' {
+		typ = tokenLiteralString
+	}
+	delimiter := strings.Repeat(string(quote), 3)
+	if lx.hasPrefix(delimiter) {
+		typ = tokenMultilineString
+		if quote == '\'' {
+			typ = tokenMultilineLiteralString
+		}
+		lx.pos += 3
+		lx.column += 3
+		for lx.pos < len(lx.input) {
+			if quote == '"' && lx.input[lx.pos] == '\\' {
+				lx.advance()
+				if lx.pos < len(lx.input) {
+					lx.advance()
+				}
+				continue
+			}
+			if lx.hasPrefix(delimiter) {
+				// one or two quotes are allowed right before the closing delimiter
+				lx.pos += 3
+				lx.column += 3
+				for i := 0; i < 2 && lx.pos < len(lx.input) && lx.input[lx.pos] == quote; i++ {
+					lx.pos++
+					lx.column++
+				}
+				return start.with(typ, lx.input[start.offset:lx.pos]), nil
+			}
+			lx.advance()
+		}
+		return token{}, lx.errorf(ErrorKindSyntax, start, "Missing closing multi-line string delimiter")
+	}
+
+	lx.advance()
+	for lx.pos < len(lx.input) && lx.input[lx.pos] != '\n' && !lx.hasPrefix("\r\n") {
+		c := lx.input[lx.pos]
+		lx.advance()
+		if c == quote {
+			return start.with(typ, lx.input[start.offset:lx.pos]), nil
+		}
+		if quote == '"' && c == '\\' && lx.pos < len(lx.input) && lx.input[lx.pos] != '\n' {
+			lx.advance()
+		}
+	}
+	return token{}, lx.errorf(ErrorKindSyntax, start, "Missing closing string delimiter")
+}
+
+// isBareKeyChar 判断是否是裸键允许的字符：A-Za-z0-9_-
+func isBareKeyChar(c rune) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' || c == '-'
+}
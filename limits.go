@@ -0,0 +1,52 @@
+package toml2php
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Resource limits for untrusted input, zero or less means no limit. Exceeding any of them stops the parsing
+// with a *ParseError of ErrorKindLimit.
+
+// MaxInputSize the maximum size of the input in bytes, default no limit
+var MaxInputSize = 0
+
+// MaxDepth the maximum nesting depth of arrays and inline tables, and the maximum number of parts of a key,
+// default 1000
+var MaxDepth = 1000
+
+// MaxKeys the maximum number of key/value pairs and table headers in a document, including those in inline tables,
+// default no limit
+var MaxKeys = 0
+
+// MaxArrayLength the maximum number of elements of an array, default no limit
+var MaxArrayLength = 0
+
+// MaxStringLength the maximum length of a string value or a key in bytes after unescaping, default no limit
+var MaxStringLength = 0
+
+// exceedsLimit 判断n是否超过限制，limit小于等于0表示不限制
+func exceedsLimit(n, limit int) bool {
+	return limit > 0 && n > limit
+}
+
+// inputSizeError 生成输入超过MaxInputSize的错误，错误位于超出限制的第一个字节。
+// 输入尚未转换编码，并且可能很长，错误中不包含出错的行
+func inputSizeError(input string) *ParseError {
+	offset := MaxInputSize
+	// do not split a UTF-8 sequence
+	for offset > 0 && !utf8.RuneStart(input[offset]) {
+		offset--
+	}
+	line, column := 1, 1
+	for _, r := range input[:offset] {
+		if r == '\n' {
+			line++
+			column = 1
+		} else if r != '\r' {
+			column++
+		}
+	}
+	msg := fmt.Sprintf("Input size %d exceeds the limit of %d bytes", len(input), MaxInputSize)
+	return &ParseError{Kind: ErrorKindLimit, Message: msg, Offset: offset, Line: line, Column: column}
+}
@@ -1,366 +1,697 @@
 package toml2php
 
 import (
-    "bytes"
     "errors"
+    "fmt"
     "strconv"
     "strings"
+    "unicode/utf8"
 )
 
-// parse Parse PHP Array
-func parse(toml string) (*PHPArray, error) {
-    phpArr := &PHPArray{}
-    
-    // normalize the toml string
-    toml, err := normalize(toml)
+// define the supported toml spec versions
+const (
+    SpecVersion10 = iota // TOML 1.0.0
+    SpecVersion11        // TOML 1.1.0, allows newlines and trailing commas in inline tables, \e and \xHH escapes, times without seconds
+)
+
+// SpecVersion the toml spec version used to parse documents, default TOML 1.0.0
+var SpecVersion = SpecVersion10
+
+// MaxErrors the maximum number of errors collected in one run, default 1, which stops at the first error.
+// Zero or less means no limit. When more than one error may be collected, the errors are returned as ParseErrors.
+var MaxErrors = 1
+
+// define parse modes
+const (
+    ParseModeStrict  = iota // reject everything the toml spec rejects
+    ParseModeLenient        // accept unquoted string values, trailing commas in inline tables and duplicate keys, with warnings
+)
+
+// ParseMode the parse mode, default ParseModeStrict. In lenient mode, the duplicate key of a value
+// is overwritten by the last one, tables are never overwritten.
+var ParseMode = ParseModeStrict
+
+// MaxWarnings the maximum number of warnings collected in lenient mode, default 100, the following ones are dropped.
+// Zero or less means no limit.
+var MaxWarnings = 100
+
+// PartialResult whether to return the table built from the valid statements together with the errors,
+// the statements which fail to parse and the key/value pairs under an invalid table header are left out
+var PartialResult = false
+
+// parser 递归下降解析器，基于lexer产生的词法单元构建PHPArray
+type parser struct {
+    lx *lexer
+    // the extensions accepted in lenient mode, reported in the same form as the errors of strict mode
+    warnings ParseErrors
+    // the current nesting depth of arrays and inline tables
+    depth int
+    // the number of key/value pairs and table headers parsed, limited by MaxKeys
+    keys int
+}
+
+// newParser 创建解析器
+func newParser(toml string) (*parser, error) {
+    lx, err := newLexer(toml)
     if err != nil {
         return nil, err
     }
+    return &parser{lx: lx}, nil
+}
 
-    // split lines
-    arrToml := strings.Split(toml, "\n")
-    arrSize := len(arrToml)
+// parseWithWarnings Parse PHP Array, and return the warnings of lenient mode. When PartialResult is set,
+// the table built from the valid statements is returned together with the errors.
+func parseWithWarnings(toml string) (*PHPArray, ParseErrors, error) {
+    p, err := newParser(toml)
+    if err != nil {
+        return nil, nil, err
+    }
+    phpArr, err := p.parseDocument()
+    return phpArr, p.warnings, err
+}
 
-    var recurseKeys []string
-    for ln := 0; ln < arrSize; ln++ {
-        line := []rune(strings.TrimSpace(arrToml[ln]))
-        lineSize := len(line)
+// parseSingleWithWarnings Parse a single value, which may be surrounded by whitespace and comments,
+// and return the warnings of lenient mode
+func parseSingleWithWarnings(snippet string) (*PHPValue, ParseErrors, error) {
+    p, err := newParser(snippet)
+    if err != nil {
+        return nil, nil, err
+    }
+    phpVal, err := p.parseSingleValue()
+    return phpVal, p.warnings, err
+}
 
-        // Skip commented and empty lines
-        if lineSize == 0 || line[0] == '#' {
-            continue
-        }
-        
-        // Array of Tables
-        if string(line[0:2]) == "[[" && string(line[lineSize-2:]) == "]]" {
-            tableName := line[2:lineSize-2]
-            aTables := parseTableName(tableName)
-            if len(aTables) <= 0 {
-                continue
-            }
-            recurseKeys = make([]string, len(aTables))
-            copy(recurseKeys, aTables)
-            phpArr.AddRecurseKeys(aTables)
-        } else if string(line[0:1]) == "[" && string(line[lineSize-1:]) == "]" {
-            tableName := line[1:lineSize-1]
-            aTables := parseTableName(tableName)
-            if len(aTables) <= 0 {
+// parseDocument 解析文档，文档由键值对和表头组成
+func (p *parser) parseDocument() (*PHPArray, error) {
+    phpArr := &PHPArray{}
+    // the table which the following key/value pairs belong to
+    curTable := phpArr
+    errs := ParseErrors{}
+loop:
+    for MaxErrors <= 0 || len(errs) < MaxErrors {
+        start := p.lx.mark()
+        tok, err := p.lx.peek(lexModeKey)
+        if err == nil {
+            switch tok.typ {
+            case tokenEOF:
+                break loop
+            case tokenNewline:
+                p.lx.next(lexModeKey)
                 continue
-            }
-            recurseKeys = make([]string, len(aTables))
-            copy(recurseKeys, aTables)
-            phpArr.AddRecurseKeys(aTables)
-        } else if runesContains(line, '=') {
-            rawLine := string(line)
-            pos := strings.Index(rawLine, "=")
-            field := strings.TrimSpace(rawLine[0:pos])
-            val := strings.TrimSpace(rawLine[pos+1:])
-            valSize := len(val)
-            if valSize >= 3 && val[0:3] == `"""` {
-                if valSize == 3 || (valSize > 3 && val[valSize-3:] != `"""`) {
-                    for {
-                        ln++
-                        nextLine := strings.TrimSpace(arrToml[ln])
-                        val += "\n"
-                        val += arrToml[ln]
-                        if nextLine == `"""` || (len(nextLine) > 3 && nextLine[len(nextLine)-3:] == `"""`) {
-                            break
-                        }
-                    }
+            case tokenLeftBracket, tokenDoubleLeftBracket:
+                var table *PHPArray
+                table, err = p.parseTableHeader(phpArr)
+                if err == nil {
+                    curTable = table
+                } else {
+                    // the following key/value pairs are checked, but not added to the result
+                    curTable = NewPHPArray()
                 }
-            }
-            if valSize >= 3 && val[0:3] == `'''` {
-                if valSize == 3 || (valSize > 3 && val[valSize-3:] != `'''`) {
-                    for {
-                        ln++
-                        nextLine := strings.TrimSpace(arrToml[ln])
-                        val += "\n"
-                        val += arrToml[ln]
-                        if nextLine == `'''` || (len(nextLine) > 3 && nextLine[len(nextLine)-3:] == `'''`) {
-                            break
-                        }
-                    }
+            default:
+                var kv *keyValue
+                kv, err = p.parseKeyValue()
+                if err == nil {
+                    err = p.expectEndOfLine("Key/value pairs have to be on a line by themselves")
                 }
-            }
-            // 支持单引号，对单引号的内容进行一次转义
-            if valSize > 2 && val[0] == '\'' && val[1] != '\'' && val[valSize-1] == '\'' {
-                buf := bytes.Buffer{}
-                valChars := []rune(val)
-                valCharsSize := len(valChars)
-                for j := 0; j < valCharsSize; j++ {
-                    if runeInArray(valChars[j], []rune{'\\', '"'}) {
-                        buf.WriteRune('\\')
-                        buf.WriteRune(valChars[j])
-                    } else {
-                        buf.WriteRune(valChars[j])
-                    }
+                if err == nil {
+                    err = p.addKeyValue(curTable, kv)
                 }
-                val = buf.String()
             }
-            k := buildRecurseKey(recurseKeys, field)
-            err = parsePHPKeyValue(phpArr, k, val)
-            if err != nil {
-                return nil, err
+        }
+        if err != nil {
+            var parseErr *ParseError
+            if !errors.As(err, &parseErr) {
+                parseErr = p.lx.errorf(ErrorKindSyntax, start, "%s", err)
+            }
+            errs = append(errs, parseErr)
+            // the rest of the input is not checked once a resource limit is exceeded
+            if parseErr.Kind == ErrorKindLimit {
+                break loop
+            }
+            // recover at the end of the statement, table headers always end at the end of the line
+            p.lx.reset(start)
+            if tok.typ == tokenLeftBracket || tok.typ == tokenDoubleLeftBracket {
+                p.lx.skipLine()
+            } else {
+                p.skipStatement()
             }
-        } else if string(line[0:1]) == "[" && string(line[lineSize-1:]) != "]" {
-            return nil, errors.New("Key groups have to be on a line by themselves: " + string(line))
-        } else {
-            return nil, errors.New("Syntax error on: " + string(line))
         }
     }
 
-    return phpArr, nil
+    if len(errs) == 0 {
+        return phpArr, nil
+    }
+    if !PartialResult {
+        phpArr = nil
+    }
+    if MaxErrors == 1 {
+        return phpArr, errs[0]
+    }
+    return phpArr, errs
 }
 
-func buildRecurseKey(precedingKeys []string, key string) string {
-    if precedingKeys == nil || len(precedingKeys) == 0 {
-        return key
+// parseSingleValue 解析单个值，值的前后可以有空白和注释
+func (p *parser) parseSingleValue() (*PHPValue, error) {
+    if err := p.skipNewlines(lexModeValue); err != nil {
+        return nil, err
+    }
+    phpVal, err := p.parseValue()
+    if err != nil {
+        return nil, err
+    }
+    if err := p.skipNewlines(lexModeValue); err != nil {
+        return nil, err
+    }
+    tok, err := p.lx.next(lexModeValue)
+    if err != nil {
+        return nil, err
+    }
+    if tok.typ != tokenEOF {
+        return nil, p.errorAt(ErrorKindSyntax, tok, "Unexpected content after value: %s", describeToken(tok))
     }
-    return strings.Join(precedingKeys, ".") + "." + key
+    return phpVal, nil
 }
 
-func parsePHPValue(val string) (*PHPValue, error) {
-    val = strings.TrimSpace(val)
-    if val == "" {
-        return nil, errors.New("Empty value not allowed")
+// looksLikeDocument 判断片段是否以键值对或表头开始，用于在解析失败时确定片段的类型
+func looksLikeDocument(snippet string) bool {
+    p, err := newParser(snippet)
+    if err != nil || p.skipNewlines(lexModeKey) != nil {
+        return false
     }
-    chars := []rune(val)
-    charsSize := len(chars)
-    parsedVal := make([]rune, 0)
-    // boolean
-    if val == "true" || val == "false" {
-        return NewPHPBoolValue(val), nil
-    }
-    // numbers
-    if isNumeric(val) {
-        return NewPHPNumberValue(val), nil
+    tok, err := p.lx.peek(lexModeKey)
+    if err != nil {
+        return false
     }
-    // Literal multi-line string
-    if string(chars[0:3]) == `'''` && string(chars[charsSize-3:charsSize]) == `'''` {
-        parsedVal = chars[3:charsSize-3]
-        if parsedVal[0] == '\n' {
-            parsedVal = parsedVal[1:]
+    switch tok.typ {
+    case tokenDoubleLeftBracket:
+        return true
+    case tokenLeftBracket:
+        // table header: [a.b]
+        p.lx.next(lexModeKey)
+        if _, err := p.parseKey(); err != nil {
+            return false
         }
-        return NewPHPStringValue(string(parsedVal)), nil
+        tok, err = p.lx.next(lexModeKey)
+        return err == nil && tok.typ == tokenRightBracket
     }
-    if string(chars[0:3]) == `"""` && string(chars[charsSize-3:charsSize]) == `"""` {
-        parsedVal = chars[3:charsSize-3]
-        if parsedVal[0] == '\n' {
-            parsedVal = parsedVal[1:]
-        }
-        return NewPHPStringValue(string(parsedVal)), nil
+    // key/value pair
+    if _, err := p.parseKey(); err != nil {
+        return false
     }
-    // Literal string
-    if chars[0] == '\'' && chars[charsSize-1] == '\'' {
-        if runesContains(chars, '\n') {
-            return nil, errors.New("New lines not allowed on single line string literals.")
+    tok, err = p.lx.next(lexModeKey)
+    return err == nil && tok.typ == tokenEquals
+}
+
+// errorAt 生成指定词法单元位置的解析错误
+func (p *parser) errorAt(kind int, tok token, format string, args ...interface{}) error {
+    return p.lx.errorf(kind, tok, format, args...)
+}
+
+// valueErrorAt 生成值的解析错误，字符串中的转义字符错误定位到出错的转义字符
+func (p *parser) valueErrorAt(tok token, err error) error {
+    escErr, ok := err.(*escapeError)
+    if !ok {
+        return p.errorAt(ErrorKindValue, tok, "%s", err)
+    }
+    // the position of the escape sequence, counted from the start of the token
+    pos := tok
+    pos.offset += escErr.offset
+    for i, r := range p.lx.input[tok.offset:pos.offset] {
+        if r == '\n' {
+            pos.line++
+            pos.column = 1
+            pos.lineStart = tok.offset + i + 1
+        } else if r != '\r' {
+            pos.column++
         }
-        return NewPHPStringValue(string(chars[1:charsSize-1])), nil
     }
-    // string
-    if chars[0] == '"' && chars[charsSize-1] == '"' {
-        return NewPHPStringValue(string(chars[1:charsSize-1])), nil
+    return p.lx.errorf(ErrorKindValue, pos, "%s", escErr.msg)
+}
+
+// warnAt 在宽松模式下记录指定词法单元位置的警告，超过MaxWarnings的警告将被忽略
+func (p *parser) warnAt(kind int, tok token, format string, args ...interface{}) {
+    if exceedsLimit(len(p.warnings)+1, MaxWarnings) {
+        return
     }
-    // Single line array (normalized)
-    if chars[0] == '[' && chars[charsSize-1] == ']' {
-        phpArr, err := parsePHPArray(chars)
-        if err != nil {
-            return nil, err
-        }
-        return NewPHPArrayValue(phpArr), nil
+    p.warnings = append(p.warnings, p.lx.errorf(kind, tok, format, args...))
+}
+
+// countKey 记录解析到的键值对或表头，超过MaxKeys时返回错误
+func (p *parser) countKey(tok token) error {
+    p.keys++
+    if exceedsLimit(p.keys, MaxKeys) {
+        return p.errorAt(ErrorKindLimit, tok, "Number of keys exceeds the limit of %d", MaxKeys)
     }
-    // Inline table (normalized)
-    if chars[0] == '{' && chars[charsSize-1] == '}' {
-        phpArr, err := parsePHPInlineTable(chars)
+    return nil
+}
+
+// checkStringLength 检查字符串或键的长度是否超过MaxStringLength
+func (p *parser) checkStringLength(tok token, str string) error {
+    if exceedsLimit(len(str), MaxStringLength) {
+        return p.errorAt(ErrorKindLimit, tok, "String length %d exceeds the limit of %d bytes", len(str), MaxStringLength)
+    }
+    return nil
+}
+
+// skipNewlines 跳过空行以及只有注释的行
+func (p *parser) skipNewlines(mode int) error {
+    for {
+        tok, err := p.lx.peek(mode)
         if err != nil {
-            return nil, err
+            return err
         }
-        return NewPHPArrayValue(phpArr), nil
+        if tok.typ != tokenNewline {
+            return nil
+        }
+        p.lx.next(mode)
     }
-    return nil, errors.New("Unknown value type: "+val)
 }
 
-func parsePHPArray(chars []rune) (*PHPArray, error) {
-    openBrackets    := 0
-    openString      := false
-    openCurlyBraces := 0
-    openLString     := false
-    buffer          := ""
-
-    charsSize := len(chars)
-    phpArr := NewPHPArray()
-    keyPos := 0
-    for i := 0; i < charsSize; i++ {
-        if chars[i] == '[' && !openString && !openLString {
-            openBrackets++
-            if openBrackets == 1 {
-                continue
-            }
-        } else if chars[i] == ']' && !openString && !openLString {
-            openBrackets--
-            if openBrackets == 0 {
-                if strings.TrimSpace(buffer) != "" {
-                    phpVal, err := parsePHPValue(strings.TrimSpace(buffer))
-                    if err != nil {
-                        return nil, err
-                    }
-                    phpArr.AddChild(strconv.Itoa(keyPos), phpVal)
-                    keyPos++
-                }
-                return phpArr, nil
-            }
-        } else if chars[i] == '"' && ((i == 0) || (i > 0 && chars[i-1] != '\\')) && !openLString {
-            openString = !openString
-        } else if chars[i] == '\'' && !openString {
-            openLString = !openLString
-        } else if chars[i] == '{' && !openString && !openLString {
-            openCurlyBraces++
-        } else if chars[i] == '}' && !openString && !openLString {
-            openCurlyBraces--
-        }
-
-        if (chars[i] == ',' || chars[i] == '}') && !openString && !openLString && openBrackets == 1 && openCurlyBraces == 0 {
-            if chars[i] == '}' {
-                buffer += string(chars[i])
+// skipStatement 跳过当前语句，语句在括号之外的第一个换行符处结束，多行数组等跨行的值会被完整跳过
+func (p *parser) skipStatement() {
+    // the key
+    mode := lexModeKey
+    depth := 0
+    for {
+        tok, err := p.lx.next(mode)
+        // tokens containing invalid characters are complete, the statement goes on after them
+        if err != nil && tok.text == "" {
+            p.lx.skipLine()
+            return
+        }
+        switch tok.typ {
+        case tokenEOF:
+            return
+        case tokenNewline:
+            if depth == 0 {
+                return
             }
-            buffer = strings.TrimSpace(buffer)
-            if buffer != "" {
-                phpVal, err := parsePHPValue(strings.TrimSpace(buffer))
-                if err != nil {
-                    return nil, err
-                }
-                phpArr.AddChild(strconv.Itoa(keyPos), phpVal)
-                keyPos++
+        case tokenEquals:
+            mode = lexModeValue
+        case tokenLeftBracket, tokenLeftBrace:
+            depth++
+        case tokenRightBracket, tokenRightBrace:
+            if depth > 0 {
+                depth--
             }
-            buffer = ""
-        } else {
-            buffer += string(chars[i])
         }
     }
-    return nil, errors.New("Wrong array definition:" + string(chars))
 }
 
-// parsePHPInlineTable Parse inline tables into common table array
-func parsePHPInlineTable(chars []rune) (*PHPArray, error) {
-    charsSize := len(chars)
-    if chars[0] == '{' && chars[charsSize-1] == '}' {
-        chars = chars[1: charsSize-1]
-    } else {
-        return nil, errors.New("Invalid inline table definition: " + string(chars))
+// expectEndOfLine 检查语句之后除注释外没有其他内容，并读取换行符
+func (p *parser) expectEndOfLine(msg string) error {
+    tok, err := p.lx.next(lexModeValue)
+    if err != nil {
+        return err
+    }
+    if tok.typ != tokenNewline && tok.typ != tokenEOF {
+        return p.errorAt(ErrorKindSyntax, tok, "%s, found %s", msg, describeToken(tok))
     }
+    return nil
+}
 
-    charsSize = len(chars)
-    openString := false
-    openLString := false
-    openBrackets := 0
-    buffer := ""
+// parseTableHeader 解析表头，如 [a.b] 或 [[a.b]]，返回后续键值对所属的表
+func (p *parser) parseTableHeader(phpArr *PHPArray) (*PHPArray, error) {
+    open, err := p.lx.next(lexModeKey)
+    if err != nil {
+        return nil, err
+    }
+    isTableArray := open.typ == tokenDoubleLeftBracket
+    keys, err := p.parseKey()
+    if err != nil {
+        return nil, err
+    }
+    closing, err := p.lx.next(lexModeKey)
+    if err != nil {
+        return nil, err
+    }
+    if (isTableArray && closing.typ != tokenDoubleRightBracket) || (!isTableArray && closing.typ != tokenRightBracket) {
+        return nil, p.errorAt(ErrorKindSyntax, closing, "Missing closing bracket of table header, found %s", describeToken(closing))
+    }
+    if err := p.expectEndOfLine("Key groups have to be on a line by themselves"); err != nil {
+        return nil, err
+    }
 
-    // save result
-    phpArr := NewPHPArray()
-    // keyPos := 0
+    if err := p.countKey(open); err != nil {
+        return nil, err
+    }
+    definedBy := p.lx.input[open.offset:closing.end()]
+    var table *PHPArray
+    if isTableArray {
+        table, err = phpArr.AddTableArrayElement(keys, definedBy)
+    } else {
+        table, err = phpArr.DefineTable(keys, definedBy)
+    }
+    if err != nil {
+        return nil, p.errorAt(ErrorKindConflict, open, "%s", err)
+    }
+    return table, nil
+}
 
-    for i := 0; i < charsSize; i++ {
-        if chars[i] == '"' && chars[i-1] != '\\' {
-            openString = !openString
-        } else if chars[i] == '\'' {
-            openLString = !openLString
-        } else if chars[i] == '[' && !openString && !openLString {
-            openBrackets++
-        } else if chars[i] == ']' && !openString && !openLString {
-            openBrackets--
+// parseKey 解析键，键由裸键、基本字符串键或字面量字符串键以点连接而成，点的两侧允许有空白。
+// 返回解码后的各级键名。
+func (p *parser) parseKey() ([]string, error) {
+    keys := make([]string, 0)
+    for {
+        tok, err := p.lx.next(lexModeKey)
+        if err != nil {
+            return nil, err
         }
-
-        if chars[i] == ',' && !openString && !openLString && openBrackets == 0 {
-            parsedPHPArr, err := parsePHPInlineTableFieldValue(buffer)
+        switch tok.typ {
+        case tokenBareKey:
+            keys = append(keys, tok.text)
+        case tokenString, tokenLiteralString:
+            key, err := decodeString(tok)
             if err != nil {
-                return nil, err
+                return nil, p.valueErrorAt(tok, err)
             }
-            // phpArr.AddChild(strconv.Itoa(keyPos), NewPHPArrayValue(parsedPHPArr))
-            phpArr.MergeChilds(parsedPHPArr)
-            // keyPos++
-            buffer = ""
-        } else {
-            buffer += string(chars[i])
+            keys = append(keys, key)
+        case tokenMultilineString, tokenMultilineLiteralString:
+            return nil, p.errorAt(ErrorKindSyntax, tok, "Multi-line strings are not allowed as keys")
+        default:
+            return nil, p.errorAt(ErrorKindSyntax, tok, "Key expected, found %s", describeToken(tok))
+        }
+        if err := p.checkStringLength(tok, keys[len(keys)-1]); err != nil {
+            return nil, err
+        }
+        if exceedsLimit(len(keys), MaxDepth) {
+            return nil, p.errorAt(ErrorKindLimit, tok, "Number of key parts exceeds the limit of %d", MaxDepth)
         }
+
+        tok, err = p.lx.peek(lexModeKey)
+        if err != nil {
+            return nil, err
+        }
+        if tok.typ != tokenDot {
+            return keys, nil
+        }
+        p.lx.next(lexModeKey)
     }
+}
+
+// keyValue 解析得到的键值对
+type keyValue struct {
+    keys      []string
+    value     *PHPValue
+    keyTok    token  // the first token of the key, where conflicts are reported
+    definedBy string // the toml snippet which defines the key
+}
 
-    // parse last buffer
-    parsedPHPArr, err := parsePHPInlineTableFieldValue(buffer)
+// parseKeyValue 解析键值对
+func (p *parser) parseKeyValue() (*keyValue, error) {
+    keyTok, err := p.lx.peek(lexModeKey)
     if err != nil {
         return nil, err
     }
-    // phpArr.AddChild(strconv.Itoa(keyPos), NewPHPArrayValue(parsedPHPArr))
-    phpArr.MergeChilds(parsedPHPArr)
-    // keyPos++
-    return phpArr, nil
+    keys, err := p.parseKey()
+    if err != nil {
+        return nil, err
+    }
+    keyEnd := p.lx.prevEnd
+    tok, err := p.lx.next(lexModeKey)
+    if err != nil {
+        return nil, err
+    }
+    if tok.typ != tokenEquals {
+        return nil, p.errorAt(ErrorKindSyntax, tok, "Expected '=' after key, found %s", describeToken(tok))
+    }
+
+    valTok, err := p.lx.peek(lexModeValue)
+    if err != nil {
+        return nil, err
+    }
+    phpVal, err := p.parseValue()
+    if err != nil {
+        return nil, err
+    }
+    definedBy := describeKeyValue(p.lx.input[keyTok.offset:keyEnd], p.lx.input[valTok.offset:p.lx.prevEnd])
+    return &keyValue{keys: keys, value: phpVal, keyTok: keyTok, definedBy: definedBy}, nil
 }
 
-// parsePHPInlineTableFieldValue 解析键值对内容
-func parsePHPInlineTableFieldValue(snippet string) (*PHPArray, error) {
-    pos := strings.Index(snippet, "=")
-    if pos <= 0 {
-        return nil, errors.New("[split] invalid inline toml table data: " + snippet)
+// addKeyValue 将键值对添加到phpArr中，重复的键将返回错误
+func (p *parser) addKeyValue(phpArr *PHPArray, kv *keyValue) error {
+    if err := p.countKey(kv.keyTok); err != nil {
+        return err
     }
-    field := strings.TrimSpace(snippet[0:pos])
-    val := strings.TrimSpace(snippet[pos+1:])
-    phpVal, err := parsePHPValue(val)
+    overwritten, err := phpArr.addDeepValue(kv.keys, kv.value, kv.definedBy, ParseMode == ParseModeLenient)
     if err != nil {
-        return nil, errors.New("[parse] invalid inline toml table data: " + val + " <= " + snippet)
+        return p.errorAt(ErrorKindConflict, kv.keyTok, "%s", err)
     }
-    phpArr := NewPHPArray()
-    phpArr.AddDeepValue([]string{field}, phpVal)
-    return phpArr, nil
+    if overwritten != nil {
+        p.warnAt(ErrorKindConflict, kv.keyTok, "Duplicate key \"%s\": already defined by \"%s\", overwritten by \"%s\"", overwritten.Key, overwritten.definedBy, kv.definedBy)
+    }
+    return nil
 }
 
-// parsePHPKeyValue 解析键值对
-func parsePHPKeyValue(phpArr *PHPArray, key, val string) error {
-    openQuote := false
-    openDoubleQuote := false
-    buffer := &bytes.Buffer{}
+// parseValue 解析值：字符串、数组、内联表或其他标量
+func (p *parser) parseValue() (*PHPValue, error) {
+    tok, err := p.lx.next(lexModeValue)
+    if err != nil {
+        return nil, err
+    }
+    switch tok.typ {
+    case tokenScalar:
+        phpVal, err := parseScalar(tok.text)
+        if errors.Is(err, errUnknownValueType) && ParseMode == ParseModeLenient {
+            if err := p.checkStringLength(tok, tok.text); err != nil {
+                return nil, err
+            }
+            p.warnAt(ErrorKindValue, tok, "Unquoted string value: %s", tok.text)
+            return NewPHPStringValue(tok.text), nil
+        }
+        if err != nil {
+            return nil, p.valueErrorAt(tok, err)
+        }
+        return phpVal, nil
+    case tokenString, tokenLiteralString, tokenMultilineString, tokenMultilineLiteralString:
+        str, err := decodeString(tok)
+        if err != nil {
+            return nil, p.valueErrorAt(tok, err)
+        }
+        if err := p.checkStringLength(tok, str); err != nil {
+            return nil, err
+        }
+        return NewPHPStringValue(str), nil
+    case tokenLeftBracket, tokenLeftBrace:
+        p.depth++
+        defer func() { p.depth-- }()
+        if exceedsLimit(p.depth, MaxDepth) {
+            return nil, p.errorAt(ErrorKindLimit, tok, "Nesting depth exceeds the limit of %d", MaxDepth)
+        }
+        var phpArr *PHPArray
+        if tok.typ == tokenLeftBracket {
+            phpArr, err = p.parseArray(tok)
+        } else {
+            phpArr, err = p.parseInlineTable(tok)
+        }
+        if err != nil {
+            return nil, err
+        }
+        return NewPHPArrayValue(phpArr), nil
+    }
+    return nil, p.errorAt(ErrorKindSyntax, tok, "Value expected, found %s", describeToken(tok))
+}
 
-    keyChars := []rune(key)
-    keyCharsSize := len(keyChars)
+// parseArray Parse arrays, which may span multiple lines, contain comments and end with a trailing comma
+func (p *parser) parseArray(open token) (*PHPArray, error) {
+    phpArr := NewPHPArray()
+    keyPos := 0
+    for {
+        if err := p.skipNewlines(lexModeValue); err != nil {
+            return nil, err
+        }
+        tok, err := p.lx.peek(lexModeValue)
+        if err != nil {
+            return nil, err
+        }
+        switch tok.typ {
+        case tokenRightBracket:
+            p.lx.next(lexModeValue)
+            return phpArr, nil
+        case tokenEOF:
+            return nil, p.errorAt(ErrorKindSyntax, open, "Missing closing bracket of array")
+        }
+        if exceedsLimit(keyPos+1, MaxArrayLength) {
+            return nil, p.errorAt(ErrorKindLimit, tok, "Array length exceeds the limit of %d", MaxArrayLength)
+        }
+        phpVal, err := p.parseValue()
+        if err != nil {
+            return nil, err
+        }
+        phpArr.AddChild(strconv.Itoa(keyPos), phpVal)
+        keyPos++
 
-    recurseKeys := make([]string, 0)
+        if err := p.skipNewlines(lexModeValue); err != nil {
+            return nil, err
+        }
+        tok, err = p.lx.next(lexModeValue)
+        if err != nil {
+            return nil, err
+        }
+        switch tok.typ {
+        case tokenComma:
+        case tokenRightBracket:
+            return phpArr, nil
+        case tokenEOF:
+            return nil, p.errorAt(ErrorKindSyntax, open, "Missing closing bracket of array")
+        default:
+            return nil, p.errorAt(ErrorKindSyntax, tok, "Array values must be separated by commas, found %s", describeToken(tok))
+        }
+    }
+}
 
-    for i := 0; i < keyCharsSize; i++ {
-        // Handle quoting
-        if keyChars[i] == '"' {
-            if !openQuote {
-                openDoubleQuote = !openDoubleQuote
-            }
-            continue
+// parseInlineTable Parse inline tables into common table array
+func (p *parser) parseInlineTable(open token) (*PHPArray, error) {
+    phpArr := NewPHPArray()
+    phpArr.kind = tableKindInline
+
+    // toml 1.1 allows newlines, comments and a trailing comma in inline tables
+    isV11 := SpecVersion >= SpecVersion11
+    skip := func() error {
+        if isV11 {
+            return p.skipNewlines(lexModeKey)
         }
-        if keyChars[i] == '\'' {
-            if !openDoubleQuote {
-                openQuote = !openQuote
-            }
-            continue
+        return nil
+    }
+    if err := skip(); err != nil {
+        return nil, err
+    }
+    tok, err := p.lx.peek(lexModeKey)
+    if err != nil {
+        return nil, err
+    }
+    if tok.typ == tokenRightBrace {
+        p.lx.next(lexModeKey)
+        return phpArr, nil
+    }
+    for {
+        kv, err := p.parseKeyValue()
+        if err != nil {
+            return nil, err
+        }
+        if err := p.addKeyValue(phpArr, kv); err != nil {
+            return nil, err
+        }
+        if err := skip(); err != nil {
+            return nil, err
+        }
+        tok, err := p.lx.next(lexModeKey)
+        if err != nil {
+            return nil, err
+        }
+        switch tok.typ {
+        case tokenRightBrace:
+            return phpArr, nil
+        case tokenComma:
+        case tokenEOF:
+            return nil, p.errorAt(ErrorKindSyntax, open, "Missing closing brace of inline table")
+        case tokenNewline:
+            return nil, p.errorAt(ErrorKindSyntax, tok, "Newlines are not allowed in inline tables, which requires toml 1.1")
+        default:
+            return nil, p.errorAt(ErrorKindSyntax, tok, "Inline table fields must be separated by commas, found %s", describeToken(tok))
         }
 
-        // Handle dotted keys
-        if keyChars[i] == '.' && !openQuote && !openDoubleQuote {
-            recurseKeys = append(recurseKeys, buffer.String())
-            buffer.Reset()
-            continue
+        if err := skip(); err != nil {
+            return nil, err
+        }
+        tok, err = p.lx.peek(lexModeKey)
+        if err != nil {
+            return nil, err
+        }
+        if tok.typ == tokenRightBrace {
+            if !isV11 && ParseMode == ParseModeLenient {
+                p.warnAt(ErrorKindSyntax, tok, "Trailing comma is not allowed in inline table, which requires toml 1.1")
+            } else if !isV11 {
+                return nil, p.errorAt(ErrorKindSyntax, tok, "Trailing comma is not allowed in inline table, which requires toml 1.1")
+            }
+            p.lx.next(lexModeKey)
+            return phpArr, nil
         }
+    }
+}
+
+// errUnknownValueType the error of unquoted values which are not booleans, numbers or date-times
+var errUnknownValueType = errors.New("Unknown value type")
 
-        buffer.WriteRune(keyChars[i])
+// parseScalar 解析布尔值、数字、日期时间等不带引号的值
+func parseScalar(val string) (*PHPValue, error) {
+    // boolean
+    if val == "true" || val == "false" {
+        return NewPHPBoolValue(val), nil
+    }
+    // numbers
+    if isNumeric(val) {
+        return parsePHPNumber(val)
+    }
+    // date-times
+    if isDateTime(val) {
+        dt, err := parseDateTime(val)
+        if err != nil {
+            return nil, err
+        }
+        return NewPHPDateTimeValue(dt), nil
+    }
+    if err := checkNumberLike(val); err != nil {
+        return nil, err
     }
+    return nil, fmt.Errorf("%w: %s", errUnknownValueType, val)
+}
 
-    if buffer.Len() > 0 {
-        recurseKeys = append(recurseKeys, buffer.String())
-        buffer.Reset()
+// decodeString 解码各种形式的字符串，多行字符串中的换行符统一为\n，并去除开始引号之后紧跟的换行符。
+// 转义字符错误的偏移为相对于词法单元开始的位置。
+func decodeString(tok token) (string, error) {
+    text := tok.text
+    switch tok.typ {
+    case tokenString:
+        str, err := unescapeBasicString(text[1:len(text)-1], false)
+        if escErr, ok := err.(*escapeError); ok {
+            escErr.offset++
+        }
+        return str, err
+    case tokenLiteralString:
+        return text[1 : len(text)-1], nil
+    }
+    str := text[3 : len(text)-3]
+    if strings.HasPrefix(str, "\n") {
+        str = str[1:]
+    } else if strings.HasPrefix(str, "\r\n") {
+        str = str[2:]
+    }
+    if tok.typ == tokenMultilineLiteralString {
+        return strings.ReplaceAll(str, "\r\n", "\n"), nil
+    }
+    prefixSize := len(text) - 3 - len(str)
+    str, err := unescapeBasicString(str, true)
+    if escErr, ok := err.(*escapeError); ok {
+        escErr.offset += prefixSize
     }
+    return str, err
+}
 
-    phpVal, err := parsePHPValue(val)
-    if err != nil {
-        return err
+// describeToken 生成词法单元的简短描述，用于错误信息
+func describeToken(tok token) string {
+    switch tok.typ {
+    case tokenEOF:
+        return "end of input"
+    case tokenNewline:
+        return "end of line"
     }
-    
-    if len(recurseKeys) > 0 {
-        phpArr.AddDeepValue(recurseKeys, phpVal)
+    desc := []rune(truncateString(tok.text, 41*utf8.UTFMax))
+    if len(desc) > 40 {
+        desc = append(desc[:40], []rune("...")...)
     }
-    return nil
+    return strconv.Quote(string(desc))
 }
 
+// describeKeyValue 生成键值对的简短描述，用于错误信息
+func describeKeyValue(key, val string) string {
+    // cut the source before converting it, the value may be large and nested in other values
+    desc := []rune(truncateString(key, 61*utf8.UTFMax) + " = " + truncateString(val, 61*utf8.UTFMax))
+    if pos := runesIndex(desc, '\n'); pos >= 0 {
+        desc = append(desc[:pos], []rune(" ...")...)
+    }
+    if len(desc) > 60 {
+        desc = append(desc[:60], []rune(" ...")...)
+    }
+    return string(desc)
+}
@@ -2,6 +2,8 @@ package toml2php
 
 import (
 	"bytes"
+	"errors"
+	"strconv"
 	"strings"
 
 	"github.com/whencome/toml2php/util"
@@ -14,6 +16,17 @@ const (
 	PhpTypeString
 	PhpTypeValue
 	PhpTypeArray
+	PhpTypeDateTime
+)
+
+// define how a table is defined, toml limits how tables of each kind can be extended later
+const (
+	tableKindNone     = iota // not a table, such as the root table and static arrays
+	tableKindImplicit        // super table created by a header, such as a of [a.b]
+	tableKindHeader          // defined by a header, such as [a]
+	tableKindDotted          // created by dotted keys, such as a of a.b = 1
+	tableKindInline          // inline table, such as { b = 1 }
+	tableKindArray           // array of tables created by [[a]], the values are the tables in order
 )
 
 // define indent string, default 4 whitespace
@@ -23,6 +36,29 @@ var IndentString = "    "
 var PHPArrayStartString = "array("
 var PHPArrayEndString = ")"
 
+// php versions which change the syntax of number literals
+const (
+	phpVersionBinaryLiteral    = 50400 // 0b1010
+	phpVersionNumericSeparator = 70400 // 1_000_000
+	phpVersionExplicitOctal    = 80100 // 0o755
+)
+
+// TargetPHPVersion the php version of the generated code, in the form of PHP_VERSION_ID.
+// Number literals which the version does not support are written in decimal.
+var TargetPHPVersion = 50400
+
+// define how to handle integers beyond the range of the target php platform
+const (
+	IntOverflowError  = iota // report an error
+	IntOverflowString        // write the integer as a decimal string
+)
+
+// PHPIntSize the integer size of the target php platform in bits, 64 or 32
+var PHPIntSize = 64
+
+// IntOverflowMode how to handle integers beyond PHP_INT_MAX when PHPIntSize is 32
+var IntOverflowMode = IntOverflowError
+
 // PHPValue define a php value
 type PHPValue struct {
 	Value interface{}
@@ -38,11 +74,16 @@ type PHPKeyValuePair struct {
 	Key   string
 	Value interface{}
 	Type  int
+
+	definedBy string // the toml snippet which defines the key, used in error messages
 }
 
 // PHPArray define a php array （array & map）
 type PHPArray struct {
 	Values []*PHPKeyValuePair
+
+	kind  int                         // how the table is defined, see tableKindHeader etc.
+	index map[string]*PHPKeyValuePair // the first pair of each key in Values, rebuilt when Values is changed outside
 }
 
 func NewPHPArray() *PHPArray {
@@ -80,6 +121,14 @@ func NewPHPStringValue(val string) *PHPValue {
 	}
 }
 
+// NewPHPDateTimeValue create a PHP date-time value
+func NewPHPDateTimeValue(val *PHPDateTime) *PHPValue {
+	return &PHPValue{
+		Value: val,
+		Type:  PhpTypeDateTime,
+	}
+}
+
 // NewPHPArrayValue create a PHP boolean value
 func NewPHPArrayValue(val *PHPArray) *PHPValue {
 	return &PHPValue{
@@ -90,43 +139,45 @@ func NewPHPArrayValue(val *PHPArray) *PHPValue {
 
 // String format PHPValue as php code
 func (phpVal *PHPValue) String(depth int) string {
-	switch phpVal.Type {
-	case PhpTypeBoolean:
-		return util.NewValue(phpVal.Value).String()
-	case PhpTypeNumber:
-		return util.NewValue(phpVal.Value).String()
-	case PhpTypeString:
-		return fmtPhpString(util.NewValue(phpVal.Value).String())
-	case PhpTypeArray:
-		phpArr := phpVal.Value.(*PHPArray)
-		return phpArr.String(depth)
-	case PhpTypeValue:
-		phpVal := phpVal.Value.(*PHPValue)
-		return phpVal.String(depth)
-	}
-	return ""
+	buf := bytes.Buffer{}
+	writeValue(&buf, phpVal.Type, phpVal.Value, depth)
+	return buf.String()
 }
 
 func (phpKV *PHPKeyValuePair) GetValue(depth int) string {
-	switch phpKV.Type {
+	buf := bytes.Buffer{}
+	writeValue(&buf, phpKV.Type, phpKV.Value, depth)
+	return buf.String()
+}
+
+// writeValue write the php code of a value to buf, nested arrays are written to the same buffer
+// instead of being formatted level by level
+func writeValue(buf *bytes.Buffer, typ int, value interface{}, depth int) {
+	switch typ {
 	case PhpTypeBoolean:
-		return util.NewValue(phpKV.Value).String()
+		buf.WriteString(util.NewValue(value).String())
 	case PhpTypeNumber:
-		return util.NewValue(phpKV.Value).String()
+		buf.WriteString(fmtPhpNumber(util.NewValue(value).String()))
 	case PhpTypeString:
-		return fmtPhpString(util.NewValue(phpKV.Value).String())
+		buf.WriteString(fmtPhpString(util.NewValue(value).String()))
+	case PhpTypeDateTime:
+		buf.WriteString(value.(*PHPDateTime).String())
 	case PhpTypeArray:
-		phpArr := phpKV.Value.(*PHPArray)
-		return phpArr.String(depth)
+		value.(*PHPArray).writeTo(buf, depth)
 	case PhpTypeValue:
-		phpVal := phpKV.Value.(*PHPValue)
-		return phpVal.String(depth)
+		phpVal := value.(*PHPValue)
+		writeValue(buf, phpVal.Type, phpVal.Value, depth)
 	}
-	return ""
 }
 
 func (phpKV *PHPKeyValuePair) String(depth int) string {
 	buf := bytes.Buffer{}
+	phpKV.writeTo(&buf, depth)
+	return buf.String()
+}
+
+// writeTo write the php code of the key/value pair to buf
+func (phpKV *PHPKeyValuePair) writeTo(buf *bytes.Buffer, depth int) {
 	buf.WriteString(strings.Repeat(IndentString, depth))
 	if isPositiveIntNumeric(phpKV.Key) {
 		buf.WriteString(phpKV.Key)
@@ -134,8 +185,7 @@ func (phpKV *PHPKeyValuePair) String(depth int) string {
 		buf.WriteString(fmtPhpString(phpKV.Key))
 	}
 	buf.WriteString(" => ")
-	buf.WriteString(phpKV.GetValue(depth))
-	return buf.String()
+	writeValue(buf, phpKV.Type, phpKV.Value, depth)
 }
 
 func NewNumberKey(v string) *PHPKey {
@@ -159,90 +209,226 @@ func (phpKey *PHPKey) String() string {
 	return fmtPhpString(phpKey.Value)
 }
 
-// AddRecurseKeys add/initialize recursed keys
+// AddRecurseKeys add/initialize recursed keys, such as [a.b.c]. Existing tables are reused and the value of a key
+// is replaced by a table, no conflicts are checked, use DefineTable to detect them.
 func (phpArr *PHPArray) AddRecurseKeys(fields []string) {
+	refPhpArr := phpArr
+	for _, field := range fields {
+		// if the key wrapped in a quotation marks, then we should remove the quotation marks first
+		fieldSize := len(field)
+		if fieldSize >= 2 && (field[0] == '"' || field[0] == '\'') && field[fieldSize-1] == field[0] {
+			field = field[1 : fieldSize-1]
+		}
+		refPhpArr = refPhpArr.subTable(field)
+	}
+}
+
+// subTable return the sub table of the key, which is created or replaces the value of the key when it is not a table.
+// It is used to build trees without checking how the tables are defined.
+func (phpArr *PHPArray) subTable(field string) *PHPArray {
+	kv := phpArr.findKey(field)
+	if kv == nil {
+		return phpArr.addTable(field, tableKindNone, field)
+	}
+	if kv.Type != PhpTypeArray {
+		kv.Type = PhpTypeArray
+		kv.Value = NewPHPArray()
+	}
+	return kv.Value.(*PHPArray)
+}
+
+// DefineTable define the table specified by fields, such as [a.b.c], and return the table.
+// definedBy is the header which defines the table, it is used to report conflicting definitions.
+func (phpArr *PHPArray) DefineTable(fields []string, definedBy string) (*PHPArray, error) {
 	fieldsSize := len(fields)
 	if fieldsSize == 0 {
-		return
+		return nil, errors.New("Empty table name")
+	}
+	refPhpArr, err := phpArr.walkTables(fields[:fieldsSize-1], definedBy)
+	if err != nil {
+		return nil, err
 	}
+	field := fields[fieldsSize-1]
+	kv := refPhpArr.findKey(field)
+	if kv == nil {
+		return refPhpArr.addTable(field, tableKindHeader, definedBy), nil
+	}
+	if kv.Type != PhpTypeArray {
+		return nil, kv.conflictError(definedBy)
+	}
+	table := kv.Value.(*PHPArray)
+	switch table.kind {
+	case tableKindImplicit:
+		// the table was created implicitly by a sub table, now it is defined
+		table.kind = tableKindHeader
+		kv.definedBy = definedBy
+		return table, nil
+	case tableKindDotted:
+		return nil, errors.New("Table \"" + field + "\" is defined by dotted keys \"" + kv.definedBy + "\", cannot be redefined by \"" + definedBy + "\"")
+	}
+	return nil, errors.New("Table \"" + field + "\" is already defined by \"" + kv.definedBy + "\", conflicts with \"" + definedBy + "\"")
+}
+
+// AddTableArrayElement append a new table to the array of tables specified by fields,
+// the new table will be returned for the following key/value pairs
+func (phpArr *PHPArray) AddTableArrayElement(fields []string, definedBy string) (*PHPArray, error) {
+	fieldsSize := len(fields)
+	if fieldsSize == 0 {
+		return nil, errors.New("Empty array of tables name")
+	}
+	refPhpArr, err := phpArr.walkTables(fields[:fieldsSize-1], definedBy)
+	if err != nil {
+		return nil, err
+	}
+	field := fields[fieldsSize-1]
+	var tables *PHPArray
+	if kv := refPhpArr.findKey(field); kv != nil {
+		if kv.Type != PhpTypeArray || kv.Value.(*PHPArray).kind != tableKindArray {
+			return nil, errors.New("Key \"" + field + "\" is already defined by \"" + kv.definedBy + "\" and is not an array of tables, conflicts with \"" + definedBy + "\"")
+		}
+		tables = kv.Value.(*PHPArray)
+	} else {
+		tables = refPhpArr.addTable(field, tableKindArray, definedBy)
+	}
+	return tables.addTable(strconv.Itoa(len(tables.Values)), tableKindHeader, definedBy), nil
+}
+
+// walkTables find or create the super tables of a table header. For an array of tables,
+// the most recently defined element is used.
+func (phpArr *PHPArray) walkTables(fields []string, definedBy string) (*PHPArray, error) {
 	refPhpArr := phpArr
-	for i := 0; i < fieldsSize; i++ {
-		field := fields[i]
-		// if the key wrapped in a quotation marks, then we should remove the quotation marks first
-		fieldChars := []rune(field)
-		fieldCharSize := len(fieldChars)
-		if (fieldChars[0] == '"' && fieldChars[fieldCharSize-1] == '"') ||
-			(fieldChars[0] == '\'' && fieldChars[fieldCharSize-1] == '\'') {
-			field = string(fieldChars[1:fieldCharSize-1])
+	for _, field := range fields {
+		kv := refPhpArr.findKey(field)
+		if kv == nil {
+			refPhpArr = refPhpArr.addTable(field, tableKindImplicit, definedBy)
+			continue
 		}
-		// initialize the found flag
-		found := false
-		for _, v := range refPhpArr.Values {
-			if v.Key == field && v.Type == PhpTypeArray {
-				refPhpArr = v.Value.(*PHPArray)
-				found = true
-			}
+		if kv.Type != PhpTypeArray {
+			return nil, kv.conflictError(definedBy)
 		}
-		if !found {
-			arr := NewPHPArray()
-			kvPair := &PHPKeyValuePair{
-				Key:   field,
-				Type:  PhpTypeArray,
-				Value: arr,
-			}
-			refPhpArr.Values = append(refPhpArr.Values, kvPair)
-			refPhpArr = arr
+		refPhpArr = kv.Value.(*PHPArray)
+		if refPhpArr.kind == tableKindArray && len(refPhpArr.Values) > 0 {
+			refPhpArr = refPhpArr.Values[len(refPhpArr.Values)-1].Value.(*PHPArray)
+		}
+	}
+	return refPhpArr, nil
+}
+
+// addTable add an empty sub table of the specified kind
+func (phpArr *PHPArray) addTable(field string, kind int, definedBy string) *PHPArray {
+	table := NewPHPArray()
+	table.kind = kind
+	phpArr.appendPair(&PHPKeyValuePair{
+		Key:       field,
+		Type:      PhpTypeArray,
+		Value:     table,
+		definedBy: definedBy,
+	})
+	return table
+}
+
+// conflictError report the value can not be used as a table
+func (phpKV *PHPKeyValuePair) conflictError(definedBy string) error {
+	if val, ok := phpKV.Value.(*PHPValue); ok && val.Type == PhpTypeArray && val.Value.(*PHPArray).kind == tableKindInline {
+		return errors.New("Inline table \"" + phpKV.Key + "\" defined by \"" + phpKV.definedBy + "\" cannot be extended by \"" + definedBy + "\"")
+	}
+	return errors.New("Key \"" + phpKV.Key + "\" is already defined by \"" + phpKV.definedBy + "\" and is not a table, conflicts with \"" + definedBy + "\"")
+}
+
+// findKey find the key/value pair by key, the index of keys is rebuilt when it is out of date
+func (phpArr *PHPArray) findKey(key string) *PHPKeyValuePair {
+	if len(phpArr.index) != len(phpArr.Values) {
+		phpArr.index = make(map[string]*PHPKeyValuePair, len(phpArr.Values))
+		for i := len(phpArr.Values) - 1; i >= 0; i-- {
+			phpArr.index[phpArr.Values[i].Key] = phpArr.Values[i]
 		}
 	}
+	return phpArr.index[key]
 }
 
-// AddDeepValue add value for specified path, which may be in a deep length
+// appendPair append a key/value pair, and keep the index of keys up to date
+func (phpArr *PHPArray) appendPair(kv *PHPKeyValuePair) {
+	phpArr.Values = append(phpArr.Values, kv)
+	if phpArr.index != nil && len(phpArr.index) == len(phpArr.Values)-1 {
+		if _, ok := phpArr.index[kv.Key]; !ok {
+			phpArr.index[kv.Key] = kv
+		}
+	}
+}
+
+// AddDeepValue add value for specified path, which may be in a deep length, such as a.b.c = 1.
+// The missing tables are created and an existing key is replaced, no conflicts are checked,
+// use DefineDeepValue to detect them.
 func (phpArr *PHPArray) AddDeepValue(paths []string, val *PHPValue) {
 	pathSize := len(paths)
-	refPhpArr := phpArr
 	if pathSize == 0 {
 		return
 	}
-	var refKVPair *PHPKeyValuePair
-	for i := 0; i < pathSize; i++ {
+	refPhpArr := phpArr
+	for _, field := range paths[:pathSize-1] {
+		refPhpArr = refPhpArr.subTable(field)
+	}
+	field := paths[pathSize-1]
+	if kv := refPhpArr.findKey(field); kv != nil {
+		kv.Type = PhpTypeValue
+		kv.Value = val
+		return
+	}
+	refPhpArr.AddChild(field, val)
+}
+
+// DefineDeepValue add value for specified path like AddDeepValue, duplicate keys and conflicting
+// definitions are reported. definedBy is the key/value pair which defines the value, it is used in the error.
+func (phpArr *PHPArray) DefineDeepValue(paths []string, val *PHPValue, definedBy string) error {
+	_, err := phpArr.addDeepValue(paths, val, definedBy, false)
+	return err
+}
+
+// addDeepValue add value for specified path like DefineDeepValue. If overwrite is true, a duplicate key of a value
+// is overwritten by the new value instead of reporting an error, and the overwritten key/value pair is returned.
+// Tables are never overwritten.
+func (phpArr *PHPArray) addDeepValue(paths []string, val *PHPValue, definedBy string, overwrite bool) (*PHPKeyValuePair, error) {
+	pathSize := len(paths)
+	if pathSize == 0 {
+		return nil, nil
+	}
+	refPhpArr := phpArr
+	for i := 0; i < pathSize-1; i++ {
 		field := paths[i]
-		// 遍历查找
-		found := false
-		for _, v := range refPhpArr.Values {
-			if v.Key == field && v.Type == PhpTypeArray {
-				refPhpArr = v.Value.(*PHPArray)
-				found = true
-				if i == pathSize-1 {
-					refKVPair = v
-				}
-			}
+		kv := refPhpArr.findKey(field)
+		if kv == nil {
+			refPhpArr = refPhpArr.addTable(field, tableKindDotted, definedBy)
+			continue
 		}
-		if !found {
-			if i == pathSize-1 {
-				kvPair := &PHPKeyValuePair{
-					Key:   field,
-					Type:  PhpTypeValue,
-					Value: val,
-				}
-				refKVPair = kvPair
-				refPhpArr.Values = append(refPhpArr.Values, kvPair)
-			} else {
-				kvPair := &PHPKeyValuePair{
-					Key:   field,
-					Type:  PhpTypeArray,
-					Value: NewPHPArray(),
-				}
-				refKVPair = kvPair
-				refPhpArr.Values = append(refPhpArr.Values, kvPair)
-				refPhpArr = refKVPair.Value.(*PHPArray)
-			}
-		} else {
-			if i == pathSize-1 {
-				refKVPair.Type = PhpTypeValue
-				refKVPair.Value = val
-			}
+		if kv.Type != PhpTypeArray {
+			return nil, kv.conflictError(definedBy)
+		}
+		refPhpArr = kv.Value.(*PHPArray)
+		switch refPhpArr.kind {
+		case tableKindArray:
+			return nil, errors.New("Array of tables \"" + field + "\" defined by \"" + kv.definedBy + "\" cannot be extended by dotted keys \"" + definedBy + "\"")
+		case tableKindHeader, tableKindImplicit:
+			return nil, errors.New("Table \"" + field + "\" defined by \"" + kv.definedBy + "\" cannot be extended by dotted keys \"" + definedBy + "\"")
+		}
+	}
+	field := paths[pathSize-1]
+	if kv := refPhpArr.findKey(field); kv != nil {
+		if !overwrite || kv.Type != PhpTypeValue {
+			return nil, errors.New("Duplicate key \"" + field + "\": already defined by \"" + kv.definedBy + "\", conflicts with \"" + definedBy + "\"")
 		}
+		overwritten := *kv
+		kv.Type = PhpTypeValue
+		kv.Value = val
+		kv.definedBy = definedBy
+		return &overwritten, nil
 	}
+	refPhpArr.appendPair(&PHPKeyValuePair{
+		Key:       field,
+		Type:      PhpTypeValue,
+		Value:     val,
+		definedBy: definedBy,
+	})
+	return nil, nil
 }
 
 func (phpArr *PHPArray) AddChild(key string, val *PHPValue) {
@@ -251,30 +437,33 @@ func (phpArr *PHPArray) AddChild(key string, val *PHPValue) {
 		Type:  PhpTypeValue,
 		Value: val,
 	}
-	phpArr.Values = append(phpArr.Values, kvPair)
+	phpArr.appendPair(kvPair)
 }
 
+// MergeChilds merge the values of arr, the value of a duplicate key is overwritten by the one in arr
 func (phpArr *PHPArray) MergeChilds(arr *PHPArray) {
 	if arr == nil || len(arr.Values) == 0 {
 		return
 	}
 	for _, v := range arr.Values {
-		found := false
-		for _, ov := range phpArr.Values {
-			if v.Key == ov.Key {
-				ov.Value = v.Value
-				found = true
-				break
-			}
-		}
-		if !found {
-			phpArr.Values = append(phpArr.Values, v)
+		if ov := phpArr.findKey(v.Key); ov != nil {
+			ov.Type = v.Type
+			ov.Value = v.Value
+			ov.definedBy = v.definedBy
+			continue
 		}
+		phpArr.appendPair(v)
 	}
 }
 
 func (phpArr *PHPArray) String(depth int) string {
 	result := bytes.Buffer{}
+	phpArr.writeTo(&result, depth)
+	return result.String()
+}
+
+// writeTo write the php code of the array to buf
+func (phpArr *PHPArray) writeTo(result *bytes.Buffer, depth int) {
 	result.WriteString(PHPArrayStartString)
 	if phpArr != nil {
 		valSize := len(phpArr.Values)
@@ -282,7 +471,7 @@ func (phpArr *PHPArray) String(depth int) string {
 			result.WriteString("\n")
 			for i, kv := range phpArr.Values {
 				result.WriteString(IndentString)
-				result.WriteString(kv.String(depth + 1))
+				kv.writeTo(result, depth+1)
 				if i != valSize-1 {
 					result.WriteString(",")
 				}
@@ -292,5 +481,4 @@ func (phpArr *PHPArray) String(depth int) string {
 		}
 	}
 	result.WriteString(PHPArrayEndString)
-	return result.String()
 }
@@ -1,29 +1,255 @@
 package toml2php
 
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // SetIndent 设置缩进字符
 func SetIndent(indent string) {
 	IndentString = indent
 }
 
-// ParseSingle 解析单个值，如整数、浮点数、字符串、布尔值等等
-func ParseSingle(snippet string) (string, error) {
-	// normalize the toml string
-	toml, err := normalize(snippet)
+// SetSpecVersion 设置解析使用的toml规范版本，可选值为SpecVersion10、SpecVersion11
+func SetSpecVersion(version int) {
+	SpecVersion = version
+}
+
+// SetMaxErrors 设置一次解析中最多收集的错误数量，默认为1，即遇到第一个错误时停止；小于等于0表示不限制。
+// 可以收集多个错误时，返回的错误为ParseErrors，解析会在出错语句的末尾恢复并继续检查后续的语句
+func SetMaxErrors(max int) {
+	MaxErrors = max
+}
+
+// SetPartialResult 设置解析失败时是否返回由有效语句生成的PHP代码，可用于编辑器预览等场景，
+// 解析失败的语句以及无效表头下的键值对不会出现在结果中，通常与SetMaxErrors(0)一起使用
+func SetPartialResult(enable bool) {
+	PartialResult = enable
+}
+
+// SetParseMode 设置解析模式，可选值为ParseModeStrict（默认，严格遵循toml规范）、ParseModeLenient（宽松模式）。
+// 宽松模式接受不带引号的字符串值、内联表末尾的逗号以及重复的键（后定义的值覆盖先定义的值），并通过*WithWarnings方法返回警告
+func SetParseMode(mode int) {
+	ParseMode = mode
+}
+
+// SetMaxWarnings 设置宽松模式下最多收集的警告数量，默认为100，超过的警告将被忽略；小于等于0表示不限制
+func SetMaxWarnings(max int) {
+	MaxWarnings = max
+}
+
+// SetMaxInputSize 设置输入的最大字节数，小于等于0表示不限制（默认）
+func SetMaxInputSize(size int) {
+	MaxInputSize = size
+}
+
+// SetMaxDepth 设置数组和内联表的最大嵌套层数以及键的最大层数，默认为1000，小于等于0表示不限制
+func SetMaxDepth(depth int) {
+	MaxDepth = depth
+}
+
+// SetMaxKeys 设置文档中键值对和表头的最大总数（包括内联表中的键值对），小于等于0表示不限制（默认）
+func SetMaxKeys(keys int) {
+	MaxKeys = keys
+}
+
+// SetMaxArrayLength 设置数组的最大元素个数，小于等于0表示不限制（默认）
+func SetMaxArrayLength(length int) {
+	MaxArrayLength = length
+}
+
+// SetMaxStringLength 设置字符串值以及键在转义之后的最大字节数，小于等于0表示不限制（默认）
+func SetMaxStringLength(length int) {
+	MaxStringLength = length
+}
+
+// SetInputEncoding 设置输入的编码，如GBK、GB18030、Big5，默认为UTF-8，输入在解析之前转换为UTF-8；
+// 错误中的行号、列号与原始输入一致，Offset为原始输入中的字节偏移，Source为转换后的内容
+func SetInputEncoding(name string) error {
+	if err := checkEncoding(name); err != nil {
+		return err
+	}
+	InputEncoding = name
+	return nil
+}
+
+// SetDetectInputEncoding 设置是否自动识别输入的编码：有效的UTF-8输入按UTF-8解析，
+// 其他输入按照InputEncoding转换，InputEncoding为UTF-8时按GB18030转换
+func SetDetectInputEncoding(enable bool) {
+	DetectInputEncoding = enable
+}
+
+// SetOutputEncoding 设置生成的PHP代码的编码，如GBK、GB18030、Big5，默认为UTF-8
+func SetOutputEncoding(name string) error {
+	if err := checkEncoding(name); err != nil {
+		return err
+	}
+	OutputEncoding = name
+	return nil
+}
+
+// SetUnencodableMode 设置无法用OutputEncoding表示的字符的处理方式，
+// 可选值为UnencodableError（默认，返回错误）、UnencodableReplace（替换为UnencodableMarker）
+func SetUnencodableMode(mode int) {
+	UnencodableMode = mode
+}
+
+// SetUnencodableMarker 设置UnencodableReplace模式下无法表示的字符的替换内容，默认为?
+func SetUnencodableMarker(marker string) {
+	UnencodableMarker = marker
+}
+
+// SetTargetPHPVersion 设置生成代码的目标PHP版本，如7.4、8.1.0
+func SetTargetPHPVersion(version string) error {
+	parts := strings.Split(version, ".")
+	if len(parts) > 3 {
+		return errors.New("Invalid php version: " + version)
+	}
+	versionID := 0
+	for i, weight := range []int{10000, 100, 1} {
+		if i >= len(parts) {
+			break
+		}
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 || (i > 0 && n > 99) {
+			return errors.New("Invalid php version: " + version)
+		}
+		versionID += n * weight
+	}
+	TargetPHPVersion = versionID
+	return nil
+}
+
+// SetPHPIntSize 设置目标PHP平台的整数位数，可选值为64、32
+func SetPHPIntSize(bits int) error {
+	if bits != 32 && bits != 64 {
+		return errors.New("Invalid php integer size: " + strconv.Itoa(bits))
+	}
+	PHPIntSize = bits
+	return nil
+}
+
+// SetIntOverflowMode 设置32位PHP平台上超出PHP_INT_MAX范围的整数的处理方式，可选值为IntOverflowError、IntOverflowString
+func SetIntOverflowMode(mode int) {
+	IntOverflowMode = mode
+}
+
+// SetDateTimeFormat 设置日期时间的输出形式，可选值为DateTimeAsString、DateTimeAsTimestamp、DateTimeAsObject
+func SetDateTimeFormat(format int) {
+	DateTimeFormat = format
+}
+
+// SetLocalTimeZone 设置本地日期时间（没有时区偏移）所使用的时区，如Asia/Shanghai
+func SetLocalTimeZone(name string) error {
+	if name == "" || name == "Local" {
+		return errors.New("Time zone name required")
+	}
+	loc, err := time.LoadLocation(name)
 	if err != nil {
-		return "", err
+		return err
+	}
+	LocalTimeZone = loc
+	return nil
+}
+
+// define the kinds of snippets detected by Parse
+const (
+	SnippetValue    = iota // a single value, such as 1, "x", [1, 2], {a = 1}
+	SnippetDocument        // a document with key/value pairs and tables
+)
+
+// Parse 解析任意toml片段，自动识别是单个值还是包含键值对和表的文档，返回PHP代码以及识别出的类型。
+// 设置了SetPartialResult时，文档解析失败也会返回由有效语句生成的PHP代码
+func Parse(snippet string) (string, int, error) {
+	code, kind, _, err := ParseWithWarnings(snippet)
+	return code, kind, err
+}
+
+// ParseWithWarnings 与Parse相同，同时返回宽松模式下使用非标准写法产生的警告。
+// 两种类型都可以解析时，优先选择警告较少的一种，警告数量相同时识别为单个值
+func ParseWithWarnings(snippet string) (code string, kind int, warnings ParseErrors, err error) {
+	defer recoverPanic(&err)
+	code, kind, warnings, err = parseSnippet(snippet)
+	code, err = encodeCode(code, err)
+	return code, kind, warnings, err
+}
+
+// parseSnippet 自动识别片段的类型并生成PHP代码
+func parseSnippet(snippet string) (string, int, ParseErrors, error) {
+	phpVal, valWarnings, valErr := parseSingleWithWarnings(snippet)
+	if valErr == nil && len(valWarnings) == 0 {
+		return phpVal.String(0), SnippetValue, nil, nil
+	}
+	phpArr, docWarnings, docErr := parseWithWarnings(snippet)
+	if valErr == nil && (docErr != nil || len(valWarnings) <= len(docWarnings)) {
+		return phpVal.String(0), SnippetValue, valWarnings, nil
+	}
+	if docErr == nil {
+		return phpArr.String(0), SnippetDocument, docWarnings, nil
 	}
-	phpVal, err := parsePHPValue(toml)
+	// both failed, report the error of the more likely kind
+	if !looksLikeDocument(snippet) {
+		return "", SnippetValue, valWarnings, valErr
+	}
+	if phpArr != nil {
+		return phpArr.String(0), SnippetDocument, docWarnings, docErr
+	}
+	return "", SnippetDocument, docWarnings, docErr
+}
+
+// encodeCode 将生成的PHP代码转换为OutputEncoding编码，转换失败时返回转换的错误，否则返回原有的错误
+func encodeCode(code string, err error) (string, error) {
+	if code == "" {
+		return code, err
+	}
+	code, encodeErr := encodeOutput(code)
+	if encodeErr != nil {
+		return "", encodeErr
+	}
+	return code, err
+}
+
+// ParseSingle 解析单个值，如整数、浮点数、字符串、布尔值等等
+func ParseSingle(snippet string) (string, error) {
+	code, _, err := ParseSingleWithWarnings(snippet)
+	return code, err
+}
+
+// ParseSingleWithWarnings 与ParseSingle相同，同时返回宽松模式下使用非标准写法产生的警告
+func ParseSingleWithWarnings(snippet string) (code string, warnings ParseErrors, err error) {
+	defer recoverPanic(&err)
+	phpVal, warnings, err := parseSingleWithWarnings(snippet)
 	if err != nil {
-		return "", err
+		return "", warnings, err
 	}
-	return phpVal.String(0), nil
+	code, err = encodeCode(phpVal.String(0), nil)
+	return code, warnings, err
 }
 
-// ParseTable 解析数组
+// ParseTable 解析数组，设置了SetPartialResult时，解析失败也会返回由有效语句生成的PHP代码
 func ParseTable(snippet string) (string, error) {
-	phpArr, err := parse(snippet)
-	if err != nil {
-		return "", err
+	code, _, err := ParseTableWithWarnings(snippet)
+	return code, err
+}
+
+// ParseTableWithWarnings 与ParseTable相同，同时返回宽松模式下使用非标准写法产生的警告
+func ParseTableWithWarnings(snippet string) (code string, warnings ParseErrors, err error) {
+	defer recoverPanic(&err)
+	phpArr, warnings, err := parseWithWarnings(snippet)
+	if phpArr == nil {
+		return "", warnings, err
+	}
+	code, err = encodeCode(phpArr.String(0), err)
+	return code, warnings, err
+}
+
+// recoverPanic 将解析过程中意外的panic转换为错误，保证公开的解析方法不会panic。
+// 这类错误不是*ParseError，表示toml2php自身的缺陷，模糊测试会将其视为失败
+func recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("Internal error: %v", r)
 	}
-	return phpArr.String(0), nil
 }
@@ -0,0 +1,107 @@
+//go:build go1.18
+// +build go1.18
+
+package toml2php
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// applyFuzzOptions 按照options的各个位设置解析选项，使模糊测试覆盖宽松模式、toml 1.1、错误恢复以及编码转换，
+// 返回恢复默认选项的函数
+func applyFuzzOptions(options byte) func() {
+	if options&1 != 0 {
+		SetSpecVersion(SpecVersion11)
+	}
+	if options&2 != 0 {
+		SetParseMode(ParseModeLenient)
+	}
+	if options&4 != 0 {
+		SetMaxErrors(0)
+		SetPartialResult(true)
+	}
+	if options&8 != 0 {
+		SetDetectInputEncoding(true)
+	}
+	if options&16 != 0 {
+		SetOutputEncoding("GBK")
+		SetUnencodableMode(UnencodableReplace)
+	}
+	return func() {
+		SetSpecVersion(SpecVersion10)
+		SetParseMode(ParseModeStrict)
+		SetMaxErrors(1)
+		SetPartialResult(false)
+		SetDetectInputEncoding(false)
+		SetOutputEncoding("UTF-8")
+		SetUnencodableMode(UnencodableError)
+	}
+}
+
+// addExampleSeeds 以example.toml作为种子语料：完整的文档、每一行，以及valueOnly为true时每个键值对中的值，
+// 每个种子分别使用默认选项以及开启所有选项
+func addExampleSeeds(f *testing.F, valueOnly bool) {
+	content, err := ioutil.ReadFile("example.toml")
+	if err != nil {
+		f.Fatal(err)
+	}
+	var seeds []string
+	if !valueOnly {
+		seeds = append(seeds, string(content))
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !valueOnly {
+			seeds = append(seeds, line)
+			continue
+		}
+		if pos := strings.Index(line, "="); pos >= 0 {
+			seeds = append(seeds, strings.TrimSpace(line[pos+1:]))
+		}
+	}
+	for _, seed := range seeds {
+		f.Add(seed, byte(0))
+		f.Add(seed, byte(0xff))
+	}
+}
+
+// checkFuzzResult 检查解析结果：解析失败时返回的错误必须是带位置的*ParseError，成功时必须生成代码
+func checkFuzzResult(t *testing.T, input, code string, err error) {
+	if err != nil {
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("parse %q: expect *ParseError, got %T: %v", input, err, err)
+		}
+		if parseErr.Line < 1 || parseErr.Column < 1 || parseErr.Offset < 0 || parseErr.Offset > len(input) {
+			t.Fatalf("parse %q: invalid error position %+v", input, parseErr)
+		}
+		return
+	}
+	if code == "" {
+		t.Fatalf("parse %q: no code generated", input)
+	}
+}
+
+func FuzzParseTable(f *testing.F) {
+	addExampleSeeds(f, false)
+	f.Fuzz(func(t *testing.T, toml string, options byte) {
+		defer applyFuzzOptions(options)()
+		code, err := ParseTable(toml)
+		checkFuzzResult(t, toml, code, err)
+	})
+}
+
+func FuzzParseSingle(f *testing.F) {
+	addExampleSeeds(f, true)
+	f.Fuzz(func(t *testing.T, toml string, options byte) {
+		defer applyFuzzOptions(options)()
+		code, err := ParseSingle(toml)
+		checkFuzzResult(t, toml, code, err)
+	})
+}
@@ -1,12 +1,28 @@
 package toml2php
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/axgle/mahonia"
 )
 
-func TestNormalize(t *testing.T) {
+// parse 解析文档，忽略宽松模式的警告
+func parse(toml string) (*PHPArray, error) {
+	phpArr, _, err := parseWithWarnings(toml)
+	return phpArr, err
+}
+
+// parseSingle 解析单个值，忽略宽松模式的警告
+func parseSingle(snippet string) (*PHPValue, error) {
+	phpVal, _, err := parseSingleWithWarnings(snippet)
+	return phpVal, err
+}
+
+func TestLexer(t *testing.T) {
 	tomlFile := "example.toml"
 	file, err := os.Open(tomlFile)
 	if err != nil {
@@ -21,21 +37,72 @@ func TestNormalize(t *testing.T) {
 		t.Fail()
 	}
 
-	toml, err := normalize(string(tomlBytes))
+	lx, err := newLexer(string(tomlBytes))
 	if err != nil {
-		t.Logf("normalize toml failed: %s\n", err)
+		t.Logf("create lexer failed: %s\n", err)
 		t.Fail()
+		return
+	}
+	// keys at the start of lines, values after =
+	mode := lexModeKey
+	for {
+		tok, err := lx.next(mode)
+		if err != nil {
+			t.Logf("scan toml failed: %s\n", err)
+			t.Fail()
+			return
+		}
+		switch tok.typ {
+		case tokenEOF:
+			return
+		case tokenEquals:
+			mode = lexModeValue
+		case tokenNewline:
+			mode = lexModeKey
+		}
 	}
+}
 
-	t.Log(toml)
+func TestLexerPositions(t *testing.T) {
+	lx, err := newLexer("a = 1\n[b]  # comment\r\nc = \"中文\" ")
+	if err != nil {
+		t.Logf("create lexer failed: %s\n", err)
+		t.Fail()
+		return
+	}
+	var expects = []token{
+		{tokenBareKey, "a", 0, 1, 1, 0},
+		{tokenEquals, "=", 2, 1, 3, 0},
+		{tokenScalar, "1", 4, 1, 5, 0},
+		{tokenNewline, "\n", 5, 1, 6, 0},
+		{tokenLeftBracket, "[", 6, 2, 1, 6},
+		{tokenBareKey, "b", 7, 2, 2, 6},
+		{tokenRightBracket, "]", 8, 2, 3, 6},
+		{tokenNewline, "\r\n", 20, 2, 15, 6},
+		{tokenBareKey, "c", 22, 3, 1, 22},
+		{tokenEquals, "=", 24, 3, 3, 22},
+		{tokenString, "\"中文\"", 26, 3, 5, 22},
+		{tokenEOF, "", 35, 3, 10, 22},
+	}
+	for _, expect := range expects {
+		mode := lexModeKey
+		if expect.typ == tokenScalar || expect.typ == tokenString {
+			mode = lexModeValue
+		}
+		tok, err := lx.next(mode)
+		if err != nil || tok != expect {
+			t.Logf("expect token %+v, got %+v, %v\n", expect, tok, err)
+			t.Fail()
+			return
+		}
+	}
 }
 
 func TestParseArray(t *testing.T) {
 	tomlArr := `[ 'literal,', 'strings', 'quo"ted' ]`
-	tomlRunes := []rune(tomlArr)
-	parsed, err := parsePHPArray(tomlRunes)
+	parsed, err := parseSingle(tomlArr)
 	if err != nil {
-		t.Logf("parsePHPArray failed: %s \n", err)
+		t.Logf("parse array failed: %s \n", err)
 		t.Fail()
 	}
 	t.Log("parsed: ", parsed.String(0))
@@ -47,9 +114,9 @@ func TestParseInlineTable(t *testing.T) {
   {title = "Games", url = "/games", childs = [{title = "Game A", url = "/games/game-a", childs = []}, {title = "Game B", url = "/games/game-b", childs = []}]},
   {title = "About us", url = "/about", childs = []}
 ]`
-	parsed, err := parsePHPInlineTableFieldValue(tomlInlineTable)
+	parsed, err := parse(tomlInlineTable)
 	if err != nil {
-		t.Logf("parsePHPInlineTable failed: %s \n", err)
+		t.Logf("parse inline table failed: %s \n", err)
 		t.Fail()
 		return
 	}
@@ -64,9 +131,15 @@ func TestParsePHPKeyValue(t *testing.T) {
   {title = "Games", url = "/games", childs = [{title = "Game A", url = "/games/game-a", childs = []}, {title = "Game B", url = "/games/game-b", childs = []}]},
   {title = "About us", url = "/about", childs = []}
 ]`
-	err := parsePHPKeyValue(phpArr, key, val)
+	p, err := newParser(key + " = " + val)
+	if err == nil {
+		var kv *keyValue
+		if kv, err = p.parseKeyValue(); err == nil {
+			err = p.addKeyValue(phpArr, kv)
+		}
+	}
 	if err != nil {
-		t.Logf("parsePHPKeyValue failed: %s \n", err)
+		t.Logf("parseKeyValue failed: %s \n", err)
 		t.Fail()
 		return
 	}
@@ -98,6 +171,8 @@ func TestParseSingle(t *testing.T) {
 		// string
 		`"good"`,
 		`"hello,world"`,
+		// array
+		"[\n  1, # one\n  2,\n] # trailing comment",
 	}
 	for _, toml := range tomls {
 		rs, err := ParseSingle(toml)
@@ -135,3 +210,1176 @@ func TestParseTable(t *testing.T) {
 
 	t.Log(rs)
 }
+
+func TestParseArrayOfTables(t *testing.T) {
+	toml := `[[fruit]]
+  name = "apple"
+
+  [fruit.physical]
+    color = "red"
+
+  [[fruit.variety]]
+    name = "red delicious"
+
+  [[fruit.variety]]
+    name = "granny smith"
+
+[[fruit]]
+  name = "banana"
+
+  [[fruit.variety]]
+    name = "plantain"`
+	rs, err := parse(toml)
+	if err != nil {
+		t.Logf("parse array of tables failed: %s\n", err)
+		t.Fail()
+		return
+	}
+	fruits := rs.Values[0].Value.(*PHPArray)
+	if len(fruits.Values) != 2 {
+		t.Logf("expect 2 fruits, got %d: %s\n", len(fruits.Values), rs.String(0))
+		t.Fail()
+		return
+	}
+	apple := fruits.Values[0].Value.(*PHPArray)
+	if len(apple.Values) != 3 || apple.Values[2].Key != "variety" {
+		t.Logf("nested tables not attached to the first element: %s\n", rs.String(0))
+		t.Fail()
+		return
+	}
+	if varieties := apple.Values[2].Value.(*PHPArray); len(varieties.Values) != 2 {
+		t.Logf("expect 2 varieties, got %d\n", len(varieties.Values))
+		t.Fail()
+	}
+	t.Log(rs.String(0))
+
+	if _, err := parse("a = 1\n[[a]]"); err == nil {
+		t.Log("expect error when redefining a key as array of tables")
+		t.Fail()
+	}
+}
+
+func TestParseDateTime(t *testing.T) {
+	defer SetDateTimeFormat(DateTimeAsString)
+	var cases = []struct {
+		toml   string
+		format int
+		expect string
+	}{
+		{`1979-05-27T07:32:00Z`, DateTimeAsString, `'1979-05-27T07:32:00Z'`},
+		{`1979-05-27 00:32:00.999999-07:00`, DateTimeAsString, `'1979-05-27T00:32:00.999999-07:00'`},
+		{`1979-05-27T07:32:00`, DateTimeAsString, `'1979-05-27T07:32:00'`},
+		{`1979-05-27`, DateTimeAsString, `'1979-05-27'`},
+		{`07:32:00.5`, DateTimeAsString, `'07:32:00.5'`},
+		{`1979-05-27T07:32:00Z`, DateTimeAsTimestamp, `296638320`},
+		{`1979-05-27T00:32:00-07:00`, DateTimeAsTimestamp, `296638320`},
+		{`07:32:00`, DateTimeAsTimestamp, `'07:32:00'`},
+		{`1979-05-27T00:32:00-07:00`, DateTimeAsObject, `new \DateTimeImmutable('1979-05-27T00:32:00-07:00', new \DateTimeZone('-07:00'))`},
+		{`1979-05-27T07:32:00z`, DateTimeAsObject, `new \DateTimeImmutable('1979-05-27T07:32:00Z', new \DateTimeZone('UTC'))`},
+		{`1979-05-27`, DateTimeAsObject, `new \DateTimeImmutable('1979-05-27T00:00:00', new \DateTimeZone('UTC'))`},
+	}
+	for _, c := range cases {
+		SetDateTimeFormat(c.format)
+		rs, err := ParseSingle(c.toml)
+		if err != nil {
+			t.Logf("parse %s failed: %s\n", c.toml, err)
+			t.Fail()
+			continue
+		}
+		if rs != c.expect {
+			t.Logf("parse %s: expect %s, got %s\n", c.toml, c.expect, rs)
+			t.Fail()
+		}
+	}
+
+	for _, toml := range []string{`1979-02-30`, `1979-13-01T00:00:00`, `24:00:00`, `1979-05-27T07:32:00+25:00`} {
+		if rs, err := ParseSingle(toml); err == nil {
+			t.Logf("parse %s: expect error, got %s\n", toml, rs)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseIntegers(t *testing.T) {
+	defer SetTargetPHPVersion("5.4")
+	var cases = []struct {
+		toml    string
+		version string
+		expect  string
+	}{
+		{`1_000_000`, "5.4", `1000000`},
+		{`1_000_000`, "7.4", `1_000_000`},
+		{`0xDEADBEEF`, "5.4", `0xDEADBEEF`},
+		{`0xdead_beef`, "5.4", `3735928559`},
+		{`0xdead_beef`, "7.4", `0xdead_beef`},
+		{`0o755`, "5.4", `0755`},
+		{`0o755`, "8.1", `0o755`},
+		{`0b1010`, "5.3", `10`},
+		{`0b1010`, "5.4", `0b1010`},
+		{`0b1_010`, "7.4", `0b1_010`},
+		{`-5_349_221`, "7.0", `-5349221`},
+		{`9_224_617.445_991`, "7.0", `9224617.445991`},
+	}
+	for _, c := range cases {
+		if err := SetTargetPHPVersion(c.version); err != nil {
+			t.Logf("set php version %s failed: %s\n", c.version, err)
+			t.Fail()
+			continue
+		}
+		rs, err := ParseSingle(c.toml)
+		if err != nil {
+			t.Logf("parse %s failed: %s\n", c.toml, err)
+			t.Fail()
+			continue
+		}
+		if rs != c.expect {
+			t.Logf("parse %s for php %s: expect %s, got %s\n", c.toml, c.version, c.expect, rs)
+			t.Fail()
+		}
+	}
+
+	for _, toml := range []string{`1__000`, `_1000`, `1000_`, `0xG1`, `0o8`, `0b2`, `-0xFF`} {
+		if rs, err := ParseSingle(toml); err == nil {
+			t.Logf("parse %s: expect error, got %s\n", toml, rs)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseSpecialFloats(t *testing.T) {
+	var cases = map[string]string{
+		`inf`:  `INF`,
+		`+inf`: `INF`,
+		`-inf`: `-INF`,
+		`nan`:  `NAN`,
+		`+nan`: `NAN`,
+		`-nan`: `NAN`,
+	}
+	for toml, expect := range cases {
+		rs, err := ParseSingle(toml)
+		if err != nil {
+			t.Logf("parse %s failed: %s\n", toml, err)
+			t.Fail()
+			continue
+		}
+		if rs != expect {
+			t.Logf("parse %s: expect %s, got %s\n", toml, expect, rs)
+			t.Fail()
+		}
+	}
+
+	rs, err := parse("limit = inf\nrates = [ 1.5, -inf, nan ]")
+	if err != nil {
+		t.Logf("parse special floats in table failed: %s\n", err)
+		t.Fail()
+		return
+	}
+	t.Log(rs.String(0))
+
+	for _, toml := range []string{`0123`, `-01.5`, `00`, `1.`, `.5`, `1e`, `1.5.5`, `Inf`, `infinity`} {
+		if rs, err := ParseSingle(toml); err == nil {
+			t.Logf("parse %s: expect error, got %s\n", toml, rs)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseEscapes(t *testing.T) {
+	var cases = map[string]string{
+		`"a\tb"`:                 `'a	b'`,
+		`"caf\u00e9"`:            `'café'`,
+		`"\U0001F600!"`:          `'😀!'`,
+		`"say \"hi\""`:           `'say "hi"'`,
+		`"C:\\path\\"`:           `'C:\\path\\'`,
+		`"it's"`:                 `'it\'s'`,
+		`"x\b\f\ry"`:             "'x\b\f\ry'",
+		`'C:\Users\nodejs'`:      `'C:\\Users\\nodejs'`,
+		`'<\i\c*\s*>'`:           `'<\\i\\c*\\s*>'`,
+		`'''I [dw]on't \d{2}'''`: `'I [dw]on\'t \\d{2}'`,
+	}
+	for toml, expect := range cases {
+		rs, err := ParseSingle(toml)
+		if err != nil {
+			t.Logf("parse %s failed: %s\n", toml, err)
+			t.Fail()
+			continue
+		}
+		if rs != expect {
+			t.Logf("parse %s: expect %s, got %s\n", toml, expect, rs)
+			t.Fail()
+		}
+	}
+
+	for _, toml := range []string{`"\uD800"`, `"\U00110000"`, `"\u12"`, `"\x41"`, `"\uZZZZ"`} {
+		if rs, err := ParseSingle(toml); err == nil {
+			t.Logf("parse %s: expect error, got %s\n", toml, rs)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseTabs(t *testing.T) {
+	toml := "[\ttable\t]\n" +
+		"\tkey\t=\t\"a\tb\"\n" +
+		"literal = 'c\td'\n" +
+		"lines = '''\ncol1\tcol2\n'''\n" +
+		"basic = \"\"\"\nall:\n\tmake\"\"\"\n" +
+		"arr = [\t1,\t2\t]\n" +
+		"inline = {\tx = 1,\ty = 'e\tf'\t}\t# comment\n"
+	rs, err := parse(toml)
+	if err != nil {
+		t.Logf("parse tabs failed: %s\n", err)
+		t.Fail()
+		return
+	}
+	table := rs.Values[0].Value.(*PHPArray)
+	var expects = map[string]string{
+		"key":     "'a\tb'",
+		"literal": "'c\td'",
+		"lines":   "'col1\tcol2\n'",
+		"basic":   "'all:\n\tmake'",
+	}
+	for _, kv := range table.Values {
+		if expect, ok := expects[kv.Key]; ok && kv.GetValue(0) != expect {
+			t.Logf("%s: expect %q, got %q\n", kv.Key, expect, kv.GetValue(0))
+			t.Fail()
+		}
+	}
+	t.Log(rs.String(0))
+}
+
+func TestFmtPhpString(t *testing.T) {
+	var cases = map[string]string{
+		`prefix = "  > "`:                 `'  > '`,
+		`blank = "   "`:                   `'   '`,
+		`empty = ""`:                      `''`,
+		`path = 'C:\path\'`:               `'C:\\path\\'`,
+		`regex = '^\d+\.\$'`:              `'^\\d+\\.\\$'`,
+		`quote = "it's"`:                  `'it\'s'`,
+		"trailing = \"\"\"a  \nb\n\"\"\"": "'a  \nb\n'",
+	}
+	for toml, expect := range cases {
+		rs, err := parse(toml)
+		if err != nil {
+			t.Logf("parse %s failed: %s\n", toml, err)
+			t.Fail()
+			continue
+		}
+		if got := rs.Values[0].GetValue(0); got != expect {
+			t.Logf("parse %s: expect %s, got %s\n", toml, expect, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseLineContinuation(t *testing.T) {
+	var cases = map[string]string{
+		"k = \"\"\"\nThe quick brown \\\n\n\n  fox jumps over \\\n    the lazy dog.\"\"\"": "The quick brown fox jumps over the lazy dog.",
+		"k = \"\"\"\\\n       The quick brown \\\n       fox.\\\n       \"\"\"":            "The quick brown fox.",
+		"k = \"\"\"\r\nThe quick \\\r\n   brown \\  \r\n\r\n\tfox\"\"\"":                   "The quick brown fox",
+		"k = \"\"\"a\\\\\nb\"\"\"":             "a\\\nb",
+		"k = \"\"\"\n\nsecond line\"\"\"":      "\nsecond line",
+		"k = \"\"\"\r\nwindows\r\nlines\"\"\"": "windows\nlines",
+		"k = '''\r\nliteral \\\r\nline'''":     "literal \\\nline",
+	}
+	for toml, expect := range cases {
+		rs, err := parse(toml)
+		if err != nil {
+			t.Logf("parse %q failed: %s\n", toml, err)
+			t.Fail()
+			continue
+		}
+		if got := rs.Values[0].GetValue(0); got != fmtPhpString(expect) {
+			t.Logf("parse %q: expect %q, got %q\n", toml, fmtPhpString(expect), got)
+			t.Fail()
+		}
+	}
+
+	if rs, err := parse("k = \"\"\"a \\ b\"\"\""); err == nil {
+		t.Logf("expect error for backslash not at the end of line, got %s\n", rs.String(0))
+		t.Fail()
+	}
+}
+
+func TestParseDuplicateKeys(t *testing.T) {
+	var invalids = []string{
+		"timeout = 30\ntimeout = 60",
+		"[a]\nx = 1\n[a]\ny = 2",
+		"[a.b]\n[a]\n[a]",
+		"a = 1\na.b = 2",
+		"a = 1\n[a]",
+		"a = 1\n[a.b]",
+		"[[a]]\n[a]",
+		"a = [1, 2]\n[[a]]",
+		"a.b = 1\na.b = 2",
+		"p = { x = 1, x = 2 }",
+		"p = { a.b = 1, a.b = 2 }",
+	}
+	for _, toml := range invalids {
+		if rs, err := parse(toml); err == nil {
+			t.Logf("parse %q: expect error, got %s\n", toml, rs.String(0))
+			t.Fail()
+		} else {
+			t.Logf("parse %q: %s\n", toml, err)
+		}
+	}
+
+	_, err := parse("timeout = 30\n\ntimeout = 60")
+	if err == nil || !strings.Contains(err.Error(), "timeout = 30") || !strings.Contains(err.Error(), "timeout = 60") {
+		t.Logf("expect error naming both definitions, got %v\n", err)
+		t.Fail()
+	}
+
+	var valids = []string{
+		"[a.b]\nx = 1\n[a]\ny = 2",
+		"a.b = 1\na.c = 2",
+		"p = { a.b = 1, a.c = 2 }",
+		"[[a]]\n[a.b]\n[[a]]\n[a.b]",
+	}
+	for _, toml := range valids {
+		if _, err := parse(toml); err != nil {
+			t.Logf("parse %q failed: %s\n", toml, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseTableImmutability(t *testing.T) {
+	var invalids = []string{
+		"point = { x = 1 }\n[point]",
+		"point = { x = 1 }\npoint.y = 2",
+		"point = { x = 1 }\n[point.sub]",
+		"[product]\ntype = { name = \"Nail\" }\ntype.edible = false",
+		"a.b.c = 1\n[a.b]",
+		"[fruit]\napple.color = \"red\"\n[fruit.apple]",
+		"[a.b.c]\nz = 9\n[a]\nb.c.t = 1",
+		"[a.b.c.d]\nz = 9\n[a]\nb.c.d.k.t = 1",
+		"[[a]]\nb.x = 1\n[a.b]",
+	}
+	for _, toml := range invalids {
+		if rs, err := parse(toml); err == nil {
+			t.Logf("parse %q: expect error, got %s\n", toml, rs.String(0))
+			t.Fail()
+		} else {
+			t.Logf("parse %q: %s\n", toml, err)
+		}
+	}
+
+	_, err := parse("point = { x = 1 }\npoint.y = 2")
+	if err == nil || !strings.Contains(err.Error(), "Inline table") {
+		t.Logf("expect inline table error, got %v\n", err)
+		t.Fail()
+	}
+
+	var valids = []string{
+		"[fruit]\napple.color = \"red\"\napple.taste.sweet = true\n[fruit.apple.texture]\nsmooth = true",
+		"a.b.c = 1\na.b.d = 2\n[a.b.e]",
+		"[x.y.z]\n[x]\n[x.y]",
+	}
+	for _, toml := range valids {
+		if _, err := parse(toml); err != nil {
+			t.Logf("parse %q failed: %s\n", toml, err)
+			t.Fail()
+		}
+	}
+}
+
+// parseWholeKey 解析完整的键，键之后不允许有其他内容
+func parseWholeKey(key string) ([]string, error) {
+	p, err := newParser(key)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := p.parseKey()
+	if err != nil {
+		return nil, err
+	}
+	tok, err := p.lx.next(lexModeKey)
+	if err == nil && tok.typ != tokenEOF {
+		err = p.errorAt(ErrorKindSyntax, tok, "Unexpected %s", describeToken(tok))
+	}
+	return keys, err
+}
+
+func TestPHPArrayBuild(t *testing.T) {
+	// the tree built without the parser reuses tables and replaces existing keys, like the old parser did
+	phpArr := NewPHPArray()
+	phpArr.AddRecurseKeys([]string{"server"})
+	phpArr.AddDeepValue([]string{"server", "port"}, NewPHPNumberValue("80"))
+	phpArr.AddRecurseKeys([]string{"server", `"ssl.cert"`})
+	phpArr.AddDeepValue([]string{"server", "ssl.cert", "path"}, NewPHPStringValue("a.pem"))
+	phpArr.AddDeepValue([]string{"a", "b", "c"}, NewPHPNumberValue("1"))
+	phpArr.AddDeepValue([]string{"a", "b", "c"}, NewPHPNumberValue("2"))
+	phpArr.AddDeepValue([]string{"a", "d"}, NewPHPNumberValue("3"))
+	phpArr.AddDeepValue([]string{"a", "d", "e"}, NewPHPNumberValue("4"))
+	phpArr.AddRecurseKeys([]string{"x", "y"})
+	phpArr.AddDeepValue([]string{"x"}, NewPHPNumberValue("5"))
+	expect := `array(
+        'server' => array(
+            'port' => 80,
+            'ssl.cert' => array(
+                'path' => 'a.pem'
+            )
+        ),
+        'a' => array(
+            'b' => array(
+                'c' => 2
+            ),
+            'd' => array(
+                'e' => 4
+            )
+        ),
+        'x' => 5
+    )`
+	if rs := phpArr.String(0); rs != expect {
+		t.Logf("expect %s, got %s\n", expect, rs)
+		t.Fail()
+	}
+
+	// merged values overwrite duplicate keys
+	other := NewPHPArray()
+	other.AddChild("d", NewPHPNumberValue("6"))
+	other.AddChild("a", NewPHPNumberValue("7"))
+	phpArr.MergeChilds(other)
+	if rs := phpArr.String(0); !strings.Contains(rs, "'a' => 7,") || !strings.Contains(rs, "'d' => 6\n") {
+		t.Logf("unexpected merge result: %s\n", rs)
+		t.Fail()
+	}
+
+	// the conflicts are reported by the Define methods
+	phpArr = NewPHPArray()
+	table, err := phpArr.DefineTable([]string{"a"}, "[a]")
+	if err != nil {
+		t.Logf("define table failed: %s\n", err)
+		t.Fail()
+		return
+	}
+	if _, err := phpArr.DefineTable([]string{"a"}, "[a]"); err == nil {
+		t.Log("expect error when defining table a twice")
+		t.Fail()
+	}
+	if err := table.DefineDeepValue([]string{"x"}, NewPHPNumberValue("1"), "x = 1"); err != nil {
+		t.Logf("define value failed: %s\n", err)
+		t.Fail()
+	}
+	if err := table.DefineDeepValue([]string{"x"}, NewPHPNumberValue("2"), "x = 2"); err == nil || !strings.Contains(err.Error(), "x = 1") {
+		t.Logf("expect duplicate key error, got %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	var cases = []struct {
+		key    string
+		expect []string
+	}{
+		{`a.b.c`, []string{"a", "b", "c"}},
+		{` a . b `, []string{"a", "b"}},
+		{"a\t.\tb", []string{"a", "b"}},
+		{`site."google.com"`, []string{"site", "google.com"}},
+		{`"a\"b"`, []string{`a"b`}},
+		{`'a"b'.c`, []string{`a"b`, "c"}},
+		{`"".x`, []string{"", "x"}},
+		{`''`, []string{""}},
+		{`"caf\u00e9"`, []string{"café"}},
+		{`'C:\path'`, []string{`C:\path`}},
+		{`1234.bare-key_2`, []string{"1234", "bare-key_2"}},
+	}
+	for _, c := range cases {
+		keys, err := parseWholeKey(c.key)
+		if err != nil {
+			t.Logf("parse key %s failed: %s\n", c.key, err)
+			t.Fail()
+			continue
+		}
+		if strings.Join(keys, "|") != strings.Join(c.expect, "|") || len(keys) != len(c.expect) {
+			t.Logf("parse key %s: expect %q, got %q\n", c.key, c.expect, keys)
+			t.Fail()
+		}
+	}
+
+	for _, key := range []string{``, `a.`, `.a`, `a..b`, `"a`, `'a`, `a b`, `a$b`, `"\x"`} {
+		keys, err := parseWholeKey(key)
+		if err == nil {
+			t.Logf("parse key %s: expect error, got %q\n", key, keys)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseQuotedKeys(t *testing.T) {
+	toml := `[ a . "b.c" ]
+"x=y" = 1
+"" = 3
+[ 'lit"eral' . "esc\"aped" ]
+"q.1".'q.2' = 4
+[[ arr . "of" ]]
+k = { "a=b" = 1, 'c.d'.e = 2 }`
+	rs, err := parse(toml)
+	if err != nil {
+		t.Logf("parse quoted keys failed: %s\n", err)
+		t.Fail()
+		return
+	}
+	rs2, err := parse(`a."b.c"."x=y" = 1`)
+	if err != nil {
+		t.Logf("parse quoted keys failed: %s\n", err)
+		t.Fail()
+		return
+	}
+	if len(rs.Values) != 3 || rs.Values[1].Key != `lit"eral` {
+		t.Logf("unexpected keys: %s\n", rs.String(0))
+		t.Fail()
+	}
+	if sub := rs2.Values[0].Value.(*PHPArray).Values[0]; sub.Key != "b.c" {
+		t.Logf("expect key b.c, got %s\n", sub.Key)
+		t.Fail()
+	}
+	t.Log(rs.String(0), rs2.String(0))
+}
+
+func TestParseMultiLineArrays(t *testing.T) {
+	toml := `allow = [
+  "10.0.0.1",   # office
+  "10.0.0.2",   # vpn, with [brackets] and "quotes"
+  'C:\tmp#1',   # literal with hash
+  """multi
+line""",      # multi-line basic
+  '''raw
+"line"''',    # multi-line literal
+  { name = "x", tags = [ "a", "b" ] },
+  [ 1, [ 2, 3 ], ],
+]
+empty = [ ]
+commented = [ # only a comment
+]
+nested = [ [ "a,b", ']' ], { k = '}' } ]`
+	rs, err := parse(toml)
+	if err != nil {
+		t.Logf("parse multi-line arrays failed: %s\n", err)
+		t.Fail()
+		return
+	}
+	allow := rs.Values[0].Value.(*PHPValue).Value.(*PHPArray)
+	if len(allow.Values) != 7 {
+		t.Logf("expect 7 values, got %d: %s\n", len(allow.Values), rs.String(0))
+		t.Fail()
+		return
+	}
+	var expects = map[int]string{
+		2: `'C:\\tmp#1'`,
+		3: "'multi\nline'",
+		4: "'raw\n\"line\"'",
+	}
+	for i, expect := range expects {
+		if got := allow.Values[i].GetValue(0); got != expect {
+			t.Logf("value %d: expect %s, got %s\n", i, expect, got)
+			t.Fail()
+		}
+	}
+	for _, kv := range rs.Values[1:3] {
+		if arr := kv.Value.(*PHPValue).Value.(*PHPArray); len(arr.Values) != 0 {
+			t.Logf("%s: expect empty array, got %s\n", kv.Key, arr.String(0))
+			t.Fail()
+		}
+	}
+	t.Log(rs.String(0))
+
+	for _, toml := range []string{"a = [1 2]", "a = [1,,2]", "a = [,]", "a = [1", "a = { x = 1, }", "a = [1] b = 2"} {
+		if rs, err := parse(toml); err == nil {
+			t.Logf("parse %q: expect error, got %s\n", toml, rs.String(0))
+			t.Fail()
+		}
+	}
+}
+
+func TestSpecVersion11(t *testing.T) {
+	defer SetSpecVersion(SpecVersion10)
+	var tomls = []string{
+		"point = {\n  x = 1, # the x\n  y = 2,\n}",
+		`esc = "\e[0m\x41"`,
+		"at = 07:32",
+		"dt = 1979-05-27T07:32Z",
+	}
+	var expects = []string{
+		"x", "'\x1b[0mA'", "'07:32:00'", "'1979-05-27T07:32:00Z'",
+	}
+
+	SetSpecVersion(SpecVersion10)
+	for _, toml := range tomls {
+		if rs, err := parse(toml); err == nil {
+			t.Logf("parse %q as toml 1.0: expect error, got %s\n", toml, rs.String(0))
+			t.Fail()
+		}
+	}
+
+	SetSpecVersion(SpecVersion11)
+	for i, toml := range tomls {
+		rs, err := parse(toml)
+		if err != nil {
+			t.Logf("parse %q as toml 1.1 failed: %s\n", toml, err)
+			t.Fail()
+			continue
+		}
+		got := rs.Values[0].GetValue(0)
+		if i == 0 {
+			got = rs.Values[0].Value.(*PHPValue).Value.(*PHPArray).Values[0].Key
+		}
+		if got != expects[i] {
+			t.Logf("parse %q as toml 1.1: expect %q, got %q\n", toml, expects[i], got)
+			t.Fail()
+		}
+	}
+
+	for _, toml := range []string{`a = "\xZZ"`, `a = "\x4"`, "a = { x = 1,, }", "a = { , }"} {
+		if rs, err := parse(toml); err == nil {
+			t.Logf("parse %q as toml 1.1: expect error, got %s\n", toml, rs.String(0))
+			t.Fail()
+		}
+	}
+}
+
+func TestParseIntegerRange(t *testing.T) {
+	defer SetPHPIntSize(64)
+	defer SetIntOverflowMode(IntOverflowError)
+
+	for _, toml := range []string{`9223372036854775807`, `-9223372036854775808`, `0x7FFFFFFFFFFFFFFF`, `1.7976931348623157e308`} {
+		if _, err := ParseSingle(toml); err != nil {
+			t.Logf("parse %s failed: %s\n", toml, err)
+			t.Fail()
+		}
+	}
+	for _, toml := range []string{`99999999999999999999`, `9223372036854775808`, `-9223372036854775809`, `0xFFFFFFFFFFFFFFFF`, `1e400`} {
+		if rs, err := ParseSingle(toml); err == nil {
+			t.Logf("parse %s: expect error, got %s\n", toml, rs)
+			t.Fail()
+		}
+	}
+
+	// the smallest integer is written as an expression, so php does not read it as a float
+	for toml, expect := range map[string]string{
+		`-9223372036854775808`:       `(-9223372036854775807 - 1)`,
+		`-9_223_372_036_854_775_808`: `(-9223372036854775807 - 1)`,
+		`-9223372036854775807`:       `-9223372036854775807`,
+		`-2147483648`:                `-2147483648`,
+	} {
+		if rs, err := ParseSingle(toml); err != nil || rs != expect {
+			t.Logf("parse %s: expect %s, got %s, %v\n", toml, expect, rs, err)
+			t.Fail()
+		}
+	}
+
+	// floats keep the original literal, so php parses the same value
+	if rs, _ := ParseSingle(`0.1000000000000000055511151231257827`); rs != `0.1000000000000000055511151231257827` {
+		t.Logf("float literal changed: %s\n", rs)
+		t.Fail()
+	}
+
+	SetPHPIntSize(32)
+	if rs, err := ParseSingle(`2147483647`); err != nil || rs != `2147483647` {
+		t.Logf("parse 2147483647 on 32-bit php: %s, %v\n", rs, err)
+		t.Fail()
+	}
+	if rs, err := ParseSingle(`-2147483648`); err != nil || rs != `(-2147483647 - 1)` {
+		t.Logf("parse -2147483648 on 32-bit php: expect (-2147483647 - 1), got %s, %v\n", rs, err)
+		t.Fail()
+	}
+	if rs, err := ParseSingle(`2147483648`); err == nil {
+		t.Logf("parse 2147483648 on 32-bit php: expect error, got %s\n", rs)
+		t.Fail()
+	}
+	SetIntOverflowMode(IntOverflowString)
+	if rs, err := ParseSingle(`0x1_0000_0000`); err != nil || rs != `'4294967296'` {
+		t.Logf("parse 0x1_0000_0000 on 32-bit php: expect '4294967296', got %s, %v\n", rs, err)
+		t.Fail()
+	}
+	if rs, err := ParseSingle(`-2147483649`); err != nil || rs != `'-2147483649'` {
+		t.Logf("parse -2147483649 on 32-bit php: expect '-2147483649', got %s, %v\n", rs, err)
+		t.Fail()
+	}
+}
+
+func TestCheckCharacters(t *testing.T) {
+	rs, err := parse("\uFEFFtitle = \"TOML\"")
+	if err != nil {
+		t.Logf("parse with BOM failed: %s\n", err)
+		t.Fail()
+		return
+	}
+	if rs.Values[0].Key != "title" {
+		t.Logf("expect key title, got %q\n", rs.Values[0].Key)
+		t.Fail()
+	}
+
+	var invalids = map[string]string{
+		"a = 1\nb = \"x\xff\"":    "line 2, column 7",
+		"a = \"bell\a\"":          "U+0007",
+		"a = 'nul\x00'":           "U+0000",
+		"# comment \x1b\na = 1":   "line 1, column 11",
+		"a = \"\"\"\nx\x7f\"\"\"": "U+007F",
+		"a = 1\rb = 2":            "U+000D",
+	}
+	for toml, expect := range invalids {
+		_, err := parse(toml)
+		if err == nil || !strings.Contains(err.Error(), expect) {
+			t.Logf("parse %q: expect error containing %q, got %v\n", toml, expect, err)
+			t.Fail()
+		}
+	}
+
+	for _, toml := range []string{"a = \"tab\there\"\r\nb = 'ok'", "a = \"\\u0007 escaped\""} {
+		if _, err := parse(toml); err != nil {
+			t.Logf("parse %q failed: %s\n", toml, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseAutoDetect(t *testing.T) {
+	var cases = map[string]int{
+		"[1,2]":                         SnippetValue,
+		`"x"`:                           SnippetValue,
+		"{a=1}":                         SnippetValue,
+		"# comment\n[\n  1,\n  2,\n]\n": SnippetValue,
+		"42":                            SnippetValue,
+		"[table]\na = 1":                SnippetDocument,
+		"a = 1":                         SnippetDocument,
+		"[[a]]\nb = 2":                  SnippetDocument,
+		"[a]":                           SnippetDocument,
+	}
+	for toml, expect := range cases {
+		code, kind, err := Parse(toml)
+		if err != nil {
+			t.Logf("parse %q failed: %s\n", toml, err)
+			t.Fail()
+			continue
+		}
+		if kind != expect {
+			t.Logf("parse %q: expect kind %d, got %d\n", toml, expect, kind)
+			t.Fail()
+		}
+		if code == "" {
+			t.Logf("parse %q: empty code\n", toml)
+			t.Fail()
+		}
+	}
+
+	var invalids = map[string]int{
+		"[1, 2":          SnippetValue,
+		"\"unterminated": SnippetValue,
+		"a = ":           SnippetDocument,
+		"[a]\nb = [1,":   SnippetDocument,
+	}
+	for toml, expect := range invalids {
+		_, kind, err := Parse(toml)
+		if err == nil || kind != expect {
+			t.Logf("parse %q: expect error of kind %d, got %d, %v\n", toml, expect, kind, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	var cases = []struct {
+		toml   string
+		kind   int
+		line   int
+		column int
+		offset int
+		source string
+	}{
+		{"a = 1 2", ErrorKindSyntax, 1, 7, 6, "a = 1 2"},
+		{"x = 1\n\tb = \"a\\qb\"", ErrorKindValue, 2, 8, 13, "\tb = \"a\\qb\""},
+		{"x = \"\"\"\r\nab\r\n\\q\"\"\"", ErrorKindValue, 3, 1, 13, "\\q\"\"\""},
+		{"a = 1\r\na = 2", ErrorKindConflict, 2, 1, 7, "a = 2"},
+		{"[a\nb = 1", ErrorKindSyntax, 1, 3, 2, "[a"},
+		{"a = [1,\n  2 3]", ErrorKindSyntax, 2, 5, 12, "  2 3]"},
+		{"\uFEFFa = 'x", ErrorKindSyntax, 1, 5, 7, "a = 'x"},
+		{"a = 1\nb = \"\x01\"", ErrorKindEncoding, 2, 6, 11, "b = \"\x01\""},
+	}
+	for _, c := range cases {
+		_, err := parse(c.toml)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Logf("parse %q: expect *ParseError, got %v\n", c.toml, err)
+			t.Fail()
+			continue
+		}
+		if parseErr.Kind != c.kind || parseErr.Line != c.line || parseErr.Column != c.column || parseErr.Offset != c.offset || parseErr.Source != c.source {
+			t.Logf("parse %q: expect kind %d at %d:%d (offset %d) in %q, got %+v\n", c.toml, c.kind, c.line, c.column, c.offset, c.source, parseErr)
+			t.Fail()
+		}
+	}
+
+	_, err := ParseTable("a = \"中文\" x")
+	expect := "Key/value pairs have to be on a line by themselves, found \"x\" at line 1, column 10:\na = \"中文\" x\n           ^"
+	if err == nil || err.Error() != expect {
+		t.Logf("expect error:\n%s\ngot:\n%v\n", expect, err)
+		t.Fail()
+	}
+}
+
+func TestParseMultipleErrors(t *testing.T) {
+	SetMaxErrors(0)
+	defer SetMaxErrors(1)
+
+	toml := `title = "ok"
+bad = 1 2
+[server
+port = 80
+arr = [
+  1,
+  2 3,
+]
+name = "x"
+name = "y"
+s = "unterminated
+[[items]]
+id = 07
+`
+	_, err := parse(toml)
+	var errs ParseErrors
+	if !errors.As(err, &errs) {
+		t.Logf("expect ParseErrors, got %v\n", err)
+		t.Fail()
+		return
+	}
+	lines := make([]int, 0)
+	for _, e := range errs {
+		lines = append(lines, e.Line)
+	}
+	expect := []int{2, 3, 7, 10, 11, 13}
+	if len(lines) != len(expect) {
+		t.Logf("expect errors at lines %v, got %v:\n%s\n", expect, lines, err)
+		t.Fail()
+		return
+	}
+	for i := range expect {
+		if lines[i] != expect[i] {
+			t.Logf("expect errors at lines %v, got %v:\n%s\n", expect, lines, err)
+			t.Fail()
+			return
+		}
+	}
+	var first *ParseError
+	if !errors.As(err, &first) || first.Line != 2 {
+		t.Logf("expect the first *ParseError at line 2, got %v\n", first)
+		t.Fail()
+	}
+	// the first error is available without the list unwrapping of go 1.20
+	first = nil
+	if !errs.As(&first) || first.Line != 2 {
+		t.Logf("expect As to set the first *ParseError at line 2, got %v\n", first)
+		t.Fail()
+	}
+
+	// the number of errors is capped
+	SetMaxErrors(2)
+	_, err = parse(toml)
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Logf("expect 2 errors, got %v\n", err)
+		t.Fail()
+	}
+
+	// a single error is returned as *ParseError by default
+	SetMaxErrors(1)
+	_, err = parse(toml)
+	if _, ok := err.(*ParseError); !ok {
+		t.Logf("expect *ParseError, got %T\n", err)
+		t.Fail()
+	}
+}
+
+func TestParsePartialResult(t *testing.T) {
+	SetMaxErrors(0)
+	SetPartialResult(true)
+	defer func() {
+		SetMaxErrors(1)
+		SetPartialResult(false)
+	}()
+
+	toml := `title = "preview"
+broken = 1 2
+trailing = 3 x
+[server
+port = 80
+[db]
+host = "localhost"
+arr = [1, 2 3]
+dup = 1
+dup = 2
+`
+	code, err := ParseTable(toml)
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 5 {
+		t.Logf("expect 5 errors, got %v\n", err)
+		t.Fail()
+	}
+	for _, expect := range []string{"'title' => 'preview'", "'host' => 'localhost'", "'dup' => 1"} {
+		if !strings.Contains(code, expect) {
+			t.Logf("expect %s in partial result:\n%s\n", expect, code)
+			t.Fail()
+		}
+	}
+	for _, unexpect := range []string{"broken", "trailing", "server", "port", "'arr'", "'dup' => 2"} {
+		if strings.Contains(code, unexpect) {
+			t.Logf("unexpected %s in partial result:\n%s\n", unexpect, code)
+			t.Fail()
+		}
+	}
+
+	code, kind, err := Parse(toml)
+	if err == nil || kind != SnippetDocument || !strings.Contains(code, "'title' => 'preview'") {
+		t.Logf("expect partial document result, got %d, %v:\n%s\n", kind, err, code)
+		t.Fail()
+	}
+
+	// invalid characters are reported at their position and only fail the statement they are in
+	code, err = ParseTable("a = 1\nb = \"x\x01\"\nc = ?\n# bad \xff\nd = 4")
+	errs = nil
+	if !errors.As(err, &errs) || len(errs) != 3 || errs[0].Kind != ErrorKindEncoding || errs[0].Line != 2 || errs[0].Column != 7 ||
+		errs[2].Kind != ErrorKindEncoding || errs[2].Line != 4 {
+		t.Logf("expect 3 errors with invalid characters at line 2 and 4, got %v\n", err)
+		t.Fail()
+	}
+	if !strings.Contains(code, "'a' => 1") || !strings.Contains(code, "'d' => 4") {
+		t.Logf("expect a and d in partial result:\n%s\n", code)
+		t.Fail()
+	}
+
+	// no partial result by default
+	SetPartialResult(false)
+	if code, err := ParseTable(toml); err == nil || code != "" {
+		t.Logf("expect no result, got %v:\n%s\n", err, code)
+		t.Fail()
+	}
+}
+
+func TestParseModes(t *testing.T) {
+	toml := `name = hello
+url = http://example.com/a # comment
+point = { x = 1, y = 2, }
+port = 80
+port = 8080
+num = 07
+`
+	if _, err := parse(toml); err == nil {
+		t.Log("expect error in strict mode\n")
+		t.Fail()
+	}
+
+	SetParseMode(ParseModeLenient)
+	defer SetParseMode(ParseModeStrict)
+	if _, _, err := ParseTableWithWarnings(toml); err == nil || !strings.Contains(err.Error(), "Leading zeros") {
+		t.Logf("expect invalid numbers to be rejected in lenient mode, got %v\n", err)
+		t.Fail()
+	}
+
+	toml = strings.Replace(toml, "num = 07\n", "", 1)
+	code, warnings, err := ParseTableWithWarnings(toml)
+	if err != nil {
+		t.Logf("parse in lenient mode failed: %s\n", err)
+		t.Fail()
+		return
+	}
+	for _, expect := range []string{"'name' => 'hello'", "'url' => 'http://example.com/a'", "'y' => 2", "'port' => 8080"} {
+		if !strings.Contains(code, expect) {
+			t.Logf("expect %s in result:\n%s\n", expect, code)
+			t.Fail()
+		}
+	}
+	var lines []int
+	for _, w := range warnings {
+		lines = append(lines, w.Line)
+	}
+	if len(lines) != 4 || lines[0] != 1 || lines[1] != 2 || lines[2] != 3 || lines[3] != 5 {
+		t.Logf("expect warnings at lines [1 2 3 5], got %v:\n%s\n", lines, warnings)
+		t.Fail()
+	}
+
+	// the number of warnings is capped
+	SetMaxWarnings(2)
+	_, warnings, err = ParseTableWithWarnings("a = [x, y, z]")
+	SetMaxWarnings(100)
+	if err != nil || len(warnings) != 2 || warnings[1].Column != 9 || warnings[1].Source != "a = [x, y, z]" {
+		t.Logf("expect 2 warnings, got %v, %v\n", warnings, err)
+		t.Fail()
+	}
+
+	// a missing newline or comma is not hidden in an unquoted string
+	for _, toml := range []string{"a = 1 b = 2", "a = hello world", "a = b=c", "a = [1 2]", "a = { x = 1 y = 2 }"} {
+		if code, _, err := ParseTableWithWarnings(toml); err == nil || !strings.Contains(err.Error(), "found") {
+			t.Logf("parse %q: expect missing separator error in lenient mode, got %v:\n%s\n", toml, err, code)
+			t.Fail()
+		}
+	}
+
+	// tables are never overwritten
+	if _, err := parse("[a]\nx = 1\n[a]"); err == nil {
+		t.Log("expect error for duplicate tables in lenient mode\n")
+		t.Fail()
+	}
+
+	// the kind with fewer warnings is preferred
+	if _, kind, warnings, err := ParseWithWarnings("[table]\na = 1"); err != nil || kind != SnippetDocument || len(warnings) != 0 {
+		t.Logf("expect document without warnings, got %d, %v, %v\n", kind, warnings, err)
+		t.Fail()
+	}
+	if code, kind, warnings, err := ParseWithWarnings("hello"); err != nil || kind != SnippetValue || len(warnings) != 1 || code != "'hello'" {
+		t.Logf("expect value with a warning, got %s, %d, %v, %v\n", code, kind, warnings, err)
+		t.Fail()
+	}
+}
+
+func TestInputEncoding(t *testing.T) {
+	defer func() {
+		SetInputEncoding("UTF-8")
+		SetDetectInputEncoding(false)
+	}()
+	gbk := mahonia.NewEncoder("GBK")
+
+	if err := SetInputEncoding("unknown"); err == nil {
+		t.Log("expect error for unsupported encoding\n")
+		t.Fail()
+	}
+
+	SetInputEncoding("GBK")
+	code, err := ParseTable(gbk.ConvertString("name = \"中文\"\n"))
+	if err != nil || !strings.Contains(code, "'name' => '中文'") {
+		t.Logf("parse gbk input failed: %v\n%s\n", err, code)
+		t.Fail()
+	}
+
+	// positions refer to the original input
+	_, err = ParseTable(gbk.ConvertString("a = \"中文\"\nb = 1 2"))
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Line != 2 || parseErr.Column != 7 || parseErr.Offset != 17 {
+		t.Logf("expect error at line 2, column 7, offset 17, got %+v\n", parseErr)
+		t.Fail()
+	}
+	_, err = ParseTable(gbk.ConvertString("a = \"中文\"\n") + "b = \"\x81\"")
+	if !errors.As(err, &parseErr) || parseErr.Kind != ErrorKindEncoding || parseErr.Line != 2 || parseErr.Column != 6 || parseErr.Offset != 16 {
+		t.Logf("expect encoding error at line 2, column 6, offset 16, got %+v\n", parseErr)
+		t.Fail()
+	}
+
+	SetInputEncoding("Big5")
+	code, err = ParseTable(mahonia.NewEncoder("Big5").ConvertString("name = '繁體'"))
+	if err != nil || !strings.Contains(code, "'name' => '繁體'") {
+		t.Logf("parse big5 input failed: %v\n%s\n", err, code)
+		t.Fail()
+	}
+
+	// valid UTF-8 is kept, the others are decoded as GB18030 by default
+	SetInputEncoding("UTF-8")
+	SetDetectInputEncoding(true)
+	for _, toml := range []string{"name = \"中文\"", gbk.ConvertString("name = \"中文\"")} {
+		code, err = ParseTable(toml)
+		if err != nil || !strings.Contains(code, "'name' => '中文'") {
+			t.Logf("parse %q failed: %v\n%s\n", toml, err, code)
+			t.Fail()
+		}
+	}
+}
+
+func TestOutputEncoding(t *testing.T) {
+	defer func() {
+		SetOutputEncoding("UTF-8")
+		SetUnencodableMode(UnencodableError)
+		SetUnencodableMarker("?")
+	}()
+	gbk := mahonia.NewEncoder("GBK")
+
+	if err := SetOutputEncoding("unknown"); err == nil {
+		t.Log("expect error for unsupported encoding\n")
+		t.Fail()
+	}
+
+	SetOutputEncoding("GBK")
+	code, err := ParseTable("'中文' = \"中文\"\n")
+	if expect := gbk.ConvertString("'中文' => '中文'"); err != nil || !strings.Contains(code, expect) {
+		t.Logf("expect gbk output %q, got %v\n%q\n", expect, err, code)
+		t.Fail()
+	}
+
+	// the second byte of 乗 in GBK is \, which would escape the following quote in a single quoted string
+	code, err = ParseSingle(`"乗\\"`)
+	if expect := `"\x81\x5C\\"`; err != nil || code != expect {
+		t.Logf("expect %s, got %v %s\n", expect, err, code)
+		t.Fail()
+	}
+
+	_, err = ParseSingle(`"a😀"`)
+	if err == nil || !strings.Contains(err.Error(), "U+1F600") {
+		t.Logf("expect error for unencodable character, got %v\n", err)
+		t.Fail()
+	}
+
+	SetUnencodableMode(UnencodableReplace)
+	SetUnencodableMarker("'")
+	code, err = ParseSingle(`"a😀"`)
+	if err != nil || code != `'a\''` {
+		t.Logf("expect 'a\\'', got %v %s\n", err, code)
+		t.Fail()
+	}
+}
+
+func TestLimits(t *testing.T) {
+	defer func() {
+		SetMaxInputSize(0)
+		SetMaxDepth(1000)
+		SetMaxKeys(0)
+		SetMaxArrayLength(0)
+		SetMaxStringLength(0)
+		SetMaxErrors(1)
+	}()
+	SetMaxInputSize(32)
+	SetMaxDepth(3)
+	SetMaxKeys(4)
+	SetMaxArrayLength(3)
+	SetMaxStringLength(5)
+	SetMaxErrors(0)
+
+	tests := []struct {
+		toml   string
+		line   int
+		column int
+	}{
+		{"a = 1\nb = 2\nc = 3\nd = 4\ne = 5\nf = 6\n", 6, 3},
+		{"a = [[[[1]]]]", 1, 8},
+		{"a = {b = {c = {d = {}}}}", 1, 20},
+		{"a.b.c.d = 1", 1, 7},
+		{"a=1\nb=2\nc=3\nd=4\ne=5", 5, 1},
+		{"[a]\n[b]\n[c]\n[d]\n[e]", 5, 1},
+		{"a = [1, 2, 3, 4]", 1, 15},
+		{"a = 'abcdef'", 1, 5},
+		{"\"abc\\tdef\" = 1", 1, 1},
+	}
+	for _, test := range tests {
+		_, err := ParseTable(test.toml)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr.Kind != ErrorKindLimit || parseErr.Line != test.line || parseErr.Column != test.column {
+			t.Logf("parse %q: expect limit error at line %d, column %d, got %v\n", test.toml, test.line, test.column, err)
+			t.Fail()
+			continue
+		}
+		// the parsing stops at the first limit error
+		if errs, ok := err.(ParseErrors); ok && len(errs) != 1 {
+			t.Logf("parse %q: expect one error, got %d\n", test.toml, len(errs))
+			t.Fail()
+		}
+	}
+
+	// the description of a key/value pair only converts the beginning of a large value
+	if desc := describeKeyValue("k", strings.Repeat("中", 1<<20)); desc != "k = "+strings.Repeat("中", 56)+" ..." {
+		t.Logf("unexpected description of a large value: %q\n", desc)
+		t.Fail()
+	}
+
+	for _, toml := range []string{"a = [[[1]]]", "a = [1, 2, 3]", "'abcde' = 'abcde'", "a.b.c = 1\n[d]"} {
+		if _, err := ParseTable(toml); err != nil {
+			t.Logf("parse %q failed: %v\n", toml, err)
+			t.Fail()
+		}
+	}
+}
@@ -161,7 +161,6 @@ func (val *Value) Int64() int64 {
     default:
         return 0
     }
-    return 0
 }
 
 // Uint64 get uint64 value
@@ -218,7 +217,6 @@ func (val *Value) Uint64() uint64 {
     default:
         return 0
     }
-    return 0
 }
 
 // Float64 get float64 value
@@ -275,7 +273,6 @@ func (val *Value) Float64() float64 {
     default:
         return 0
     }
-    return 0
 }
 
 // Boolean get bool value